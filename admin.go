@@ -0,0 +1,44 @@
+package mayflycache
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// AdminHandler returns an http.Handler to change the membership of the
+// pool live, it is usually served at "/_mayflycache_admin/peers":
+//
+//	GET                     lists the peers
+//	POST   ?peer=<url>...   adds the peers
+//	DELETE ?peer=<url>...   removes the peers
+//
+// The response is the JSON array of the peers after the change.
+// The handler doesn't authenticate the requests, so it must not be served
+// on the listener of the peers, but on one only the operators can reach,
+// or behind an authenticating middleware.
+func (hp *HTTPPool) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		peers := r.Form["peer"]
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			hp.Log("Add peers %v", peers)
+			hp.AddPeers(peers...)
+		case http.MethodDelete:
+			hp.Log("Remove peers %v", peers)
+			hp.RemovePeers(peers...)
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hp.Peers())
+	})
+}
@@ -0,0 +1,73 @@
+package mayflycache_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/hey-kong/mayflycache"
+)
+
+func TestHTTPPoolMembership(t *testing.T) {
+	hp := mayflycache.NewHTTPPool("http://self")
+	if _, ok := hp.PickPeer("Name"); ok {
+		t.Fatalf("expect no peer picked before any peer is added")
+	}
+
+	hp.AddPeers("http://self", "http://peer1")
+	hp.AddPeers("http://peer1", "http://peer2")
+	if peers := hp.Peers(); !reflect.DeepEqual(peers, []string{"http://peer1", "http://peer2", "http://self"}) {
+		t.Fatalf("unexpected peers %v", peers)
+	}
+	if n := len(hp.GetAll()); n != 2 {
+		t.Fatalf("expect 2 other peers, but %d got", n)
+	}
+
+	hp.RemovePeers("http://peer1", "http://peer2")
+	for _, key := range []string{"k1", "k2", "k3", "k4"} {
+		if peer, ok := hp.PickPeer(key); ok {
+			t.Fatalf("expect self to own %s after removing other peers, but %v got", key, peer)
+		}
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	hp := mayflycache.NewHTTPPool("http://self")
+	hp.Set("http://self")
+	ts := httptest.NewServer(hp.AdminHandler())
+	defer ts.Close()
+
+	do := func(method string, peers ...string) []string {
+		req, _ := http.NewRequest(method, ts.URL+"?"+url.Values{"peer": peers}.Encode(), nil)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s failed: %v", method, err)
+		}
+		defer res.Body.Close()
+		var got []string
+		if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+			t.Fatalf("decoding %s response: %v", method, err)
+		}
+		return got
+	}
+
+	if got := do(http.MethodPost, "http://peer1", "http://peer2"); !reflect.DeepEqual(got, []string{"http://peer1", "http://peer2", "http://self"}) {
+		t.Fatalf("unexpected peers after POST: %v", got)
+	}
+	if got := do(http.MethodDelete, "http://peer1"); !reflect.DeepEqual(got, []string{"http://peer2", "http://self"}) {
+		t.Fatalf("unexpected peers after DELETE: %v", got)
+	}
+	if got := do(http.MethodGet); !reflect.DeepEqual(got, hp.Peers()) {
+		t.Fatalf("unexpected peers after GET: %v", got)
+	}
+
+	req, _ := http.NewRequest(http.MethodPatch, ts.URL, nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil || res.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("expect %d for PATCH, but %v %v got", http.StatusMethodNotAllowed, res, err)
+	}
+	res.Body.Close()
+}
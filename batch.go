@@ -0,0 +1,348 @@
+package mayflycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	pb "github.com/hey-kong/mayflycache/mayflycachepb"
+)
+
+// A BatchGetter is a Getter that also loads many keys at once, it is used
+// by Group.GetMany to load the missing keys owned by the current node.
+// The values and errs are in the order of keys, errs may be nil if all
+// the keys are loaded.
+type BatchGetter interface {
+	Getter
+	GetMany(ctx context.Context, keys []string) (values [][]byte, errs []error)
+}
+
+// A BatchExpirationGetter is a BatchGetter that also decides when the
+// loaded data expire like ExpirationGetter, the zero time means using
+// the default TTL of the Group. expires is in the order of keys.
+type BatchExpirationGetter interface {
+	BatchGetter
+	GetManyWithExpire(ctx context.Context, keys []string) (values [][]byte, expires []time.Time, errs []error)
+}
+
+// GetMany returns the values of the keys and the errors of loading them,
+// both in the order of keys. The cached keys are served locally, the
+// others are requested from their owners, one request per peer.
+func (g *Group) GetMany(ctx context.Context, keys []string) ([]Chunk, []error) {
+	return g.getMany(ctx, keys, true)
+}
+
+// getManyDetour is GetMany of the keys that a peer has sent to the current
+// node instead of their owners, they are loaded by the Getter like getDetour.
+func (g *Group) getManyDetour(ctx context.Context, keys []string) ([]Chunk, []error) {
+	return g.getMany(ctx, keys, false)
+}
+
+// A peerBatch is a peer to request a batch of keys from, the keys share
+// the route to the peer.
+type peerBatch struct {
+	peer    PeerGetter
+	replica bool // whether the current node is a replica of the keys
+	detour  bool // whether the peer is asked to load the keys routed around their owners
+}
+
+// getMany returns the values of the keys, which are loaded from the peers
+// only if forward is true.
+func (g *Group) getMany(ctx context.Context, keys []string, forward bool) ([]Chunk, []error) {
+	values := make([]Chunk, len(keys))
+	errs := make([]error, len(keys))
+	misses := make(map[string][]int) // map missing key to its indexes in keys
+	for i, key := range keys {
+		if key == "" {
+			errs[i] = fmt.Errorf("key is required")
+			continue
+		}
+		if idx, ok := misses[key]; ok {
+			misses[key] = append(idx, i)
+			continue
+		}
+		g.stats.gets.Add(1)
+		if v, ok := g.lookupCache(key); ok {
+			values[i] = v
+			continue
+		}
+		if g.lookupNegative(key) || g.rejectByFilter(key) {
+			errs[i] = ErrNotFound
+			continue
+		}
+		g.stats.misses.Add(1)
+		misses[key] = []int{i}
+	}
+	if len(misses) == 0 {
+		return values, errs
+	}
+
+	// fill is called concurrently for different keys, which
+	// write to different indexes.
+	fill := func(key string, value Chunk, err error) {
+		for _, i := range misses[key] {
+			values[i], errs[i] = value, err
+		}
+	}
+
+	// The keys are grouped by their routes like load, hot are the keys
+	// loaded by the current node instead of their owners.
+	var local, hot []string
+	batches := make(map[peerBatch][]string)
+	for key := range misses {
+		if !forward {
+			hot = append(hot, key)
+			continue
+		}
+		r := g.pickPeer(key)
+		switch {
+		case r.peer != nil:
+			b := peerBatch{peer: r.peer, replica: r.replica, detour: r.detour}
+			batches[b] = append(batches[b], key)
+		case r.owner:
+			local = append(local, key)
+		default:
+			hot = append(hot, key)
+		}
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		fallback []string // keys of the failed peers to load locally
+	)
+	for b, keys := range batches {
+		wg.Add(1)
+		go func(b peerBatch, keys []string) {
+			defer wg.Done()
+			if err := g.getManyFromPeer(ctx, b, keys, fill); err != nil {
+				g.stats.peerErrors.Add(1)
+				// Don't fall back to the Getter if the caller has given up
+				if ctx.Err() != nil {
+					for _, key := range keys {
+						fill(key, Chunk{}, ctx.Err())
+					}
+					return
+				}
+				log.Println("Failed to get from peer:", err)
+				mu.Lock()
+				fallback = append(fallback, keys...)
+				mu.Unlock()
+			}
+		}(b, keys)
+	}
+	if len(local) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.getManyLocally(ctx, local, false, fill)
+		}()
+	}
+	if len(hot) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.getManyLocally(ctx, hot, true, fill)
+		}()
+	}
+	wg.Wait()
+
+	if len(fallback) > 0 {
+		g.getManyLocally(ctx, fallback, false, fill)
+	}
+	return values, errs
+}
+
+// getManyFromPeer fills the values of the keys from the peer of the batch,
+// the errors of loading the keys on the peer are returned to the caller
+// as they are.
+func (g *Group) getManyFromPeer(ctx context.Context, b peerBatch, keys []string, fill func(string, Chunk, error)) error {
+	req := &pb.BatchRequest{
+		Group: g.name,
+		Keys:  keys,
+	}
+	res := &pb.BatchResponse{}
+	if b.detour {
+		ctx = withDetour(ctx)
+	}
+	start := time.Now()
+	err := b.peer.GetMany(ctx, req, res)
+	if err == nil && len(res.Results) != len(keys) {
+		err = fmt.Errorf("expect %d results, but %d got", len(keys), len(res.Results))
+	}
+	g.metrics.observePeer(peerName(b.peer), time.Since(start), err)
+	if err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		r := res.Results[i]
+		if r.NotFound {
+			g.cacheNegative(key, ErrNotFound)
+			fill(key, Chunk{}, ErrNotFound)
+			continue
+		}
+		if r.Error != "" {
+			fill(key, Chunk{}, errors.New(r.Error))
+			continue
+		}
+		var expire time.Time
+		if r.Expire != 0 {
+			expire = time.Unix(0, r.Expire)
+		}
+		value := NewChunkWithExpire(r.Value, expire)
+		g.stats.peerLoads.Add(1)
+		// The values are kept like the ones loaded by load
+		if b.replica {
+			g.populateCache(key, value)
+		} else if g.hotCache != nil && (b.detour || rand.Float64() < g.hotRatio) {
+			g.hotCache.Set(key, value)
+		}
+		fill(key, value, nil)
+	}
+	return nil
+}
+
+// getManyLocally fills the values of the keys by the BatchGetter in one
+// call if it is implemented, otherwise the keys are loaded concurrently.
+// The keys being loaded by other calls are waited for rather than loaded
+// again. An ExpirationGetter loads the keys in a batch only if it is a
+// BatchExpirationGetter, so the expirations it decides are kept.
+// The values are kept in the hotCache only if hot, like loadDetour.
+func (g *Group) getManyLocally(ctx context.Context, keys []string, hot bool, fill func(string, Chunk, error)) {
+	once, getLocally := &g.once, g.getLocally
+	if hot {
+		once, getLocally = &g.detours, g.getLocallyHot
+	}
+	_, batch := g.getter.(BatchGetter)
+	if _, ok := g.getter.(ExpirationGetter); ok {
+		_, batch = g.getter.(BatchExpirationGetter)
+	}
+	if !batch {
+		var wg sync.WaitGroup
+		for _, key := range keys {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				v, err := once.Do(ctx, key, func() (interface{}, error) {
+					return getLocally(ctx, key)
+				})
+				if err != nil {
+					fill(key, Chunk{}, err)
+					return
+				}
+				fill(key, v.(Chunk), nil)
+			}(key)
+		}
+		wg.Wait()
+		return
+	}
+
+	// Only the keys not in flight are loaded in the batch
+	calls := make(map[string]*call, len(keys))
+	var leaders, waiting []string
+	for _, key := range keys {
+		if _, ok := calls[key]; ok {
+			continue
+		}
+		c, leader := once.begin(key)
+		calls[key] = c
+		if leader {
+			leaders = append(leaders, key)
+		} else {
+			waiting = append(waiting, key)
+		}
+	}
+	if len(leaders) > 0 {
+		values, errs := g.getManyFromGetter(ctx, leaders)
+		for i, key := range leaders {
+			if errs[i] == nil {
+				if !hot {
+					g.populateCache(key, values[i])
+				} else if g.hotCache != nil {
+					g.hotCache.Set(key, values[i])
+				}
+			}
+			once.finish(key, calls[key], values[i], errs[i], errs[i] != nil && ctx.Err() != nil)
+			fill(key, values[i], errs[i])
+		}
+	}
+	for _, key := range waiting {
+		v, retry, err := once.wait(ctx, calls[key])
+		if retry {
+			v, err = once.Do(ctx, key, func() (interface{}, error) {
+				return getLocally(ctx, key)
+			})
+		} else {
+			g.stats.dedups.Add(1)
+		}
+		if err != nil {
+			fill(key, Chunk{}, err)
+			continue
+		}
+		fill(key, v.(Chunk), nil)
+	}
+}
+
+// getManyFromGetter loads the values of the keys in one call of the
+// BatchGetter without caching them, like getFromGetter.
+func (g *Group) getManyFromGetter(ctx context.Context, keys []string) ([]Chunk, []error) {
+	var values [][]byte
+	var expires []time.Time
+	var errs []error
+	start := time.Now()
+	if beg, ok := g.getter.(BatchExpirationGetter); ok {
+		values, expires, errs = beg.GetManyWithExpire(ctx, keys)
+	} else {
+		values, errs = g.getter.(BatchGetter).GetMany(ctx, keys)
+	}
+	g.metrics.loadLatency.observe(time.Since(start))
+
+	chunks := make([]Chunk, len(keys))
+	loadErrs := make([]error, len(keys))
+	for i, key := range keys {
+		var err error
+		if i < len(errs) && errs[i] != nil {
+			err = errs[i]
+		} else if i >= len(values) {
+			err = fmt.Errorf("%s not loaded by the BatchGetter", key)
+		}
+		if err != nil {
+			g.stats.localLoadErrs.Add(1)
+			g.cacheNegative(key, err)
+			loadErrs[i] = err
+			continue
+		}
+		g.stats.localLoads.Add(1)
+		var expire time.Time
+		if i < len(expires) {
+			expire = expires[i]
+		}
+		chunks[i] = NewChunkWithExpire(values[i], g.expireOf(expire))
+	}
+	return chunks, loadErrs
+}
+
+// batchResponse returns the BatchResponse of the results of GetMany.
+func batchResponse(values []Chunk, errs []error) *pb.BatchResponse {
+	res := &pb.BatchResponse{Results: make([]*pb.BatchResult, len(values))}
+	for i, v := range values {
+		r := &pb.BatchResult{}
+		if errors.Is(errs[i], ErrNotFound) {
+			r.NotFound = true
+		} else if errs[i] != nil {
+			r.Error = errs[i].Error()
+		} else {
+			r.Value = v.ByteSlice()
+			if e := v.Expire(); !e.IsZero() {
+				r.Expire = e.UnixNano()
+			}
+		}
+		res.Results[i] = r
+	}
+	return res
+}
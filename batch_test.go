@@ -0,0 +1,277 @@
+package mayflycache_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hey-kong/mayflycache"
+	pb "github.com/hey-kong/mayflycache/mayflycachepb"
+)
+
+// batchGetter loads the keys in batches and counts the calls,
+// the key "Unknown" doesn't exist.
+type batchGetter struct {
+	calls int
+	keys  int
+}
+
+func (bg *batchGetter) Get(ctx context.Context, key string) ([]byte, error) {
+	values, errs := bg.GetMany(ctx, []string{key})
+	return values[0], errs[0]
+}
+
+func (bg *batchGetter) GetMany(ctx context.Context, keys []string) ([][]byte, []error) {
+	bg.calls++
+	bg.keys += len(keys)
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		if key == "Unknown" {
+			errs[i] = fmt.Errorf("%s not exists", key)
+			continue
+		}
+		values[i] = []byte("db-" + key)
+	}
+	return values, errs
+}
+
+func TestGroupGetMany(t *testing.T) {
+	bg := &batchGetter{}
+	g := mayflycache.NewGroup("batch", 2<<10, bg)
+
+	keys := []string{"k1", "k2", "Unknown", "k1", ""}
+	values, errs := g.GetMany(context.Background(), keys)
+	for i, want := range []string{"db-k1", "db-k2", "", "db-k1", ""} {
+		if values[i].String() != want {
+			t.Fatalf("expect %q for %q, but %q got", want, keys[i], values[i].String())
+		}
+	}
+	if errs[0] != nil || errs[1] != nil || errs[2] == nil || errs[3] != nil || errs[4] == nil {
+		t.Fatalf("expect errors of Unknown and the empty key only, but %v got", errs)
+	}
+	if bg.calls != 1 || bg.keys != 3 {
+		t.Fatalf("expect 1 batch of 3 keys, but %d calls of %d keys got", bg.calls, bg.keys)
+	}
+
+	// The loaded keys are cached
+	g.GetMany(context.Background(), []string{"k1", "k2"})
+	if bg.calls != 1 {
+		t.Fatalf("expect the keys to be cached, but %d calls got", bg.calls)
+	}
+}
+
+// slowBatchGetter blocks the Get of a single key until release is closed,
+// records the keys of the batches, and expires the key "short" in a minute.
+type slowBatchGetter struct {
+	mu      sync.Mutex
+	batches [][]string
+	started chan struct{}
+	release chan struct{}
+}
+
+func (sg *slowBatchGetter) Get(ctx context.Context, key string) ([]byte, error) {
+	close(sg.started)
+	<-sg.release
+	return []byte("db-" + key), nil
+}
+
+func (sg *slowBatchGetter) GetMany(ctx context.Context, keys []string) ([][]byte, []error) {
+	values, _, errs := sg.GetManyWithExpire(ctx, keys)
+	return values, errs
+}
+
+func (sg *slowBatchGetter) GetManyWithExpire(ctx context.Context, keys []string) ([][]byte, []time.Time, []error) {
+	sg.mu.Lock()
+	sg.batches = append(sg.batches, keys)
+	sg.mu.Unlock()
+	values := make([][]byte, len(keys))
+	expires := make([]time.Time, len(keys))
+	for i, key := range keys {
+		values[i] = []byte("db-" + key)
+		if key == "short" {
+			expires[i] = time.Now().Add(time.Minute)
+		}
+	}
+	return values, expires, nil
+}
+
+func TestGroupGetManyInFlight(t *testing.T) {
+	sg := &slowBatchGetter{started: make(chan struct{}), release: make(chan struct{})}
+	g := mayflycache.NewGroup("batch-inflight", 2<<10, sg)
+
+	go g.Get(context.Background(), "k1")
+	<-sg.started
+
+	done := make(chan struct{})
+	var values []mayflycache.Chunk
+	var errs []error
+	go func() {
+		values, errs = g.GetMany(context.Background(), []string{"k1", "k2", "k2"})
+		close(done)
+	}()
+	// The batch of the keys not in flight doesn't wait for k1
+	if !waitFor(func() bool {
+		sg.mu.Lock()
+		defer sg.mu.Unlock()
+		return len(sg.batches) == 1
+	}) {
+		t.Fatalf("expect a batch of the keys not in flight")
+	}
+	if keys := sg.batches[0]; len(keys) != 1 || keys[0] != "k2" {
+		t.Fatalf("expect a batch of k2 only, but %v got", keys)
+	}
+	select {
+	case <-done:
+		t.Fatalf("expect GetMany to wait for the load of k1")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(sg.release)
+	<-done
+	for i, want := range []string{"db-k1", "db-k2", "db-k2"} {
+		if errs[i] != nil || values[i].String() != want {
+			t.Fatalf("expect %q, but %q and %v got", want, values[i].String(), errs[i])
+		}
+	}
+	if n := g.Stats().Dedups; n != 1 {
+		t.Fatalf("expect 1 dedup, but %d got", n)
+	}
+}
+
+func TestGroupGetManyExpire(t *testing.T) {
+	sg := &slowBatchGetter{}
+	g := mayflycache.NewGroup("batch-expire", 2<<10, sg, mayflycache.WithTTL(time.Hour))
+
+	values, _ := g.GetMany(context.Background(), []string{"short", "long"})
+	if e := values[0].Expire(); e.IsZero() || time.Until(e) > time.Minute {
+		t.Fatalf("expect short to expire in a minute, but %v got", e)
+	}
+	if e := values[1].Expire(); time.Until(e) < 59*time.Minute {
+		t.Fatalf("expect long to expire in the default TTL, but %v got", e)
+	}
+}
+
+func TestGroupGetManyFromPeer(t *testing.T) {
+	var loads int32
+	g := mayflycache.NewGroup("batch-peer", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			// The keys are loaded concurrently
+			atomic.AddInt32(&loads, 1)
+			return []byte("local-" + key), nil
+		},
+	))
+	peer := &namedPeer{}
+	g.RegisterPeers(peer)
+
+	values, errs := g.GetMany(context.Background(), []string{"k1", "k2", "k3"})
+	for i, key := range []string{"k1", "k2", "k3"} {
+		if errs[i] != nil || values[i].String() != "peer-"+key {
+			t.Fatalf("expect peer-%s, but %v (%v) got", key, values[i], errs[i])
+		}
+	}
+	if peer.batches != 1 || peer.calls != 0 {
+		t.Fatalf("expect 1 batch and no single get, but %d and %d got", peer.batches, peer.calls)
+	}
+
+	// The keys of the failed peer are loaded locally
+	peer.fail = true
+	values, errs = g.GetMany(context.Background(), []string{"k4", "k5"})
+	if errs[0] != nil || values[0].String() != "local-k4" || errs[1] != nil || values[1].String() != "local-k5" {
+		t.Fatalf("expect the keys loaded locally, but %v %v got", values, errs)
+	}
+	if n := atomic.LoadInt32(&loads); n != 2 {
+		t.Fatalf("expect 2 local loads, but %d got", n)
+	}
+}
+
+func TestHTTPPoolGetMany(t *testing.T) {
+	mayflycache.NewGroup("batch-remote", 2<<10, &batchGetter{})
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config.Handler = mayflycache.NewHTTPPool("http://" + ts.Listener.Addr().String())
+	ts.Start()
+	defer ts.Close()
+
+	client := mayflycache.NewHTTPPool("http://client")
+	client.Set(ts.URL)
+	peer, _ := client.PickPeer("k1")
+
+	res := &pb.BatchResponse{}
+	req := &pb.BatchRequest{Group: "batch-remote", Keys: []string{"k1", "Unknown"}}
+	if err := peer.GetMany(context.Background(), req, res); err != nil {
+		t.Fatalf("get many from peer failed: %v", err)
+	}
+	if len(res.Results) != 2 || string(res.Results[0].GetValue()) != "db-k1" || res.Results[1].GetError() == "" {
+		t.Fatalf("expect db-k1 and the error of Unknown, but %v got", res.Results)
+	}
+}
+
+func TestHTTPPoolGetManyDetour(t *testing.T) {
+	var owner recorder
+	ownerServer := recordServer(&owner, true)
+	defer ownerServer.Close()
+	// The other peer serves the group by its pool, counting the detours
+	var detours int32
+	otherPool := mayflycache.NewHTTPPool("http://other")
+	otherServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Mayflycache-Detour") != "" {
+			atomic.AddInt32(&detours, 1)
+		}
+		otherPool.ServeHTTP(w, r)
+	}))
+	defer otherServer.Close()
+
+	client := mayflycache.NewHTTPPool("http://client", mayflycache.WithBoundedLoad(0.25))
+	client.Set(ownerServer.URL, otherServer.URL)
+	g := mayflycache.NewGroup("batch-detour", 2<<10, localGetter(), mayflycache.WithHotCache(2<<10, 0))
+	g.RegisterPeers(client)
+	var keys []string
+	for i := 0; len(keys) < 3; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if peer, _ := client.PickOwner(key); fmt.Sprint(peer) == ownerServer.URL {
+			keys = append(keys, key)
+		}
+	}
+
+	// The requests to the owner stay in flight until it is at its bound
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := int64(1); ; i++ {
+		peer, _ := client.PickPeer(keys[0])
+		if fmt.Sprint(peer) != ownerServer.URL {
+			break
+		}
+		if i > 10 {
+			t.Fatalf("expect the keys to spill over, but %v got", client.Loads())
+		}
+		go peer.Get(ctx, &pb.Request{Group: "batch-detour", Key: keys[0]}, &pb.Response{})
+		if !waitFor(func() bool { return client.Loads()[ownerServer.URL] == i }) {
+			t.Fatalf("expect %d requests in flight, but %v got", i, client.Loads())
+		}
+	}
+	gets := atomic.LoadInt32(&owner.gets)
+
+	// The other peer loads the batch by the Getter rather than sending it back to the owner
+	batchCtx, batchCancel := context.WithTimeout(context.Background(), time.Second)
+	defer batchCancel()
+	values, errs := g.GetMany(batchCtx, keys)
+	for i, key := range keys {
+		if errs[i] != nil || values[i].String() != "local-"+key {
+			t.Fatalf("expect local-%s, but %v (%v) got", key, values[i], errs[i])
+		}
+	}
+	if n := atomic.LoadInt32(&detours); n != 1 {
+		t.Fatalf("expect a detoured batch to %s, but %d got", otherServer.URL, n)
+	}
+	if n := atomic.LoadInt32(&owner.gets); n != gets {
+		t.Fatalf("expect no request to the owner, but %d got", n-gets)
+	}
+	if s := g.Stats(); s.PeerErrors != 0 || s.MainCache.Items != 0 || s.HotCache.Items != int64(len(keys)) {
+		t.Fatalf("expect the values in the hotCache only, but %+v got", s)
+	}
+}
@@ -0,0 +1,118 @@
+// Package bloom provides a Bloom filter of the keys known to exist, it
+// reports that a key may exist or definitely doesn't exist.
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"sync/atomic"
+)
+
+// Filter is a Bloom filter, it is safe for concurrent use.
+type Filter struct {
+	bits []uint64 // accessed atomically
+	m    uint64   // number of bits
+	k    uint32   // number of hash functions
+}
+
+// New returns a Filter for n keys with the false positive rate fpRate,
+// e.g. 0.01 means 1% of the absent keys are reported to exist.
+func New(n int, fpRate float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = 0.01
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return newFilter(m, k)
+}
+
+func newFilter(m uint64, k uint32) *Filter {
+	if m < 64 {
+		m = 64
+	}
+	return &Filter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// hashes returns the two hashes of the key combined into the k
+// hash functions, h1 + i*h2 for the i-th one.
+func hashes(key string) (uint64, uint64) {
+	h1 := fnv.New64a()
+	h1.Write([]byte(key))
+	h2 := fnv.New64()
+	h2.Write([]byte(key))
+	return h1.Sum64(), h2.Sum64() | 1
+}
+
+// Add adds the key to the filter.
+func (f *Filter) Add(key string) {
+	h1, h2 := hashes(key)
+	for i := uint32(0); i < f.k; i++ {
+		bit := (h1 + uint64(i)*h2) % f.m
+		word, mask := &f.bits[bit/64], uint64(1)<<(bit%64)
+		for {
+			old := atomic.LoadUint64(word)
+			if old&mask != 0 || atomic.CompareAndSwapUint64(word, old, old|mask) {
+				break
+			}
+		}
+	}
+}
+
+// Has reports whether the key may have been added,
+// false means it definitely hasn't.
+func (f *Filter) Has(key string) bool {
+	h1, h2 := hashes(key)
+	for i := uint32(0); i < f.k; i++ {
+		bit := (h1 + uint64(i)*h2) % f.m
+		if atomic.LoadUint64(&f.bits[bit/64])&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// headerSize is the bytes of m and k before the bits in the binary form.
+const headerSize = 12
+
+// MarshalBinary encodes the filter, so it can be shared between the nodes.
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	b := make([]byte, headerSize+8*len(f.bits))
+	binary.BigEndian.PutUint64(b, f.m)
+	binary.BigEndian.PutUint32(b[8:], f.k)
+	for i := range f.bits {
+		binary.BigEndian.PutUint64(b[headerSize+8*i:], atomic.LoadUint64(&f.bits[i]))
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes the filter encoded by MarshalBinary.
+func (f *Filter) UnmarshalBinary(b []byte) error {
+	if len(b) < headerSize {
+		return errors.New("bloom: filter too short")
+	}
+	m, k := binary.BigEndian.Uint64(b), binary.BigEndian.Uint32(b[8:])
+	// The words are counted from the length, (m+63)/64 overflows
+	// for a hostile m
+	words := uint64(len(b)-headerSize) / 8
+	if m == 0 || k == 0 || uint64(len(b)-headerSize)%8 != 0 || m > 64*words || m <= 64*(words-1) {
+		return errors.New("bloom: invalid filter")
+	}
+	bits := make([]uint64, words)
+	for i := range bits {
+		bits[i] = binary.BigEndian.Uint64(b[headerSize+8*i:])
+	}
+	f.bits, f.m, f.k = bits, m, k
+	return nil
+}
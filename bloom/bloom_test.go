@@ -0,0 +1,73 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"strconv"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	f := New(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		f.Add("key" + strconv.Itoa(i))
+	}
+	for i := 0; i < 10000; i++ {
+		if !f.Has("key" + strconv.Itoa(i)) {
+			t.Fatalf("expect key%d to be in the filter", i)
+		}
+	}
+
+	fp := 0
+	for i := 0; i < 100000; i++ {
+		if f.Has("absent" + strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 100000; rate > 0.02 {
+		t.Fatalf("expect the false positive rate about 0.01, but %v got", rate)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	f := New(100, 0.001)
+	f.Add("Name")
+	f.Add("Age")
+	b, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+
+	g := &Filter{}
+	if err := g.UnmarshalBinary(b); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if !g.Has("Name") || !g.Has("Age") || g.Has("Hobby") {
+		t.Fatalf("expect the same keys in the decoded filter")
+	}
+
+	if err := g.UnmarshalBinary(b[:len(b)-1]); err == nil {
+		t.Fatalf("expect an error for the truncated filter")
+	}
+	if err := g.UnmarshalBinary(nil); err == nil {
+		t.Fatalf("expect an error for the empty filter")
+	}
+}
+
+func TestUnmarshalHostile(t *testing.T) {
+	// m+63 wraps around, so the filter of no words would be accepted
+	b := make([]byte, headerSize)
+	binary.BigEndian.PutUint64(b, 1<<64-1)
+	binary.BigEndian.PutUint32(b[8:], 1)
+	f := &Filter{}
+	if err := f.UnmarshalBinary(b); err == nil {
+		t.Fatalf("expect an error for the hostile m, but nil got")
+	}
+
+	// m is more than the bits of the words
+	b = make([]byte, headerSize+8)
+	binary.BigEndian.PutUint64(b, 65)
+	binary.BigEndian.PutUint32(b[8:], 1)
+	if err := f.UnmarshalBinary(b); err == nil {
+		t.Fatalf("expect an error for m = 65 of a word, but nil got")
+	}
+}
@@ -0,0 +1,95 @@
+package mayflycache
+
+import (
+	"sync"
+	"time"
+)
+
+// circuitState is the state of the circuit breaker of a peer.
+type circuitState int
+
+const (
+	circuitClosed   circuitState = iota // the requests are sent to the peer
+	circuitOpen                         // the peer is skipped
+	circuitHalfOpen                     // a trial request is sent to the peer
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// A breaker tracks the health of a peer, it opens after threshold
+// consecutive failures, and lets a trial request through every cooldown
+// until one succeeds and closes it again.
+type breaker struct {
+	threshold int           // consecutive failures to open, 0 means only the probes open it
+	cooldown  time.Duration // interval of the trial requests, 0 means only the probes close it
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	since    time.Time // when it was opened or the last trial was let through
+}
+
+// allow reports whether a request may be sent to the peer.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitClosed {
+		return true
+	}
+	// The trial may be abandoned without a result, so another one
+	// is let through after the cooldown.
+	if b.cooldown > 0 && time.Since(b.since) >= b.cooldown {
+		b.state = circuitHalfOpen
+		b.since = time.Now()
+		return true
+	}
+	return false
+}
+
+// success closes the breaker.
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = circuitClosed
+	b.failures = 0
+}
+
+// failure counts a failed request, a failed trial opens the breaker again.
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.threshold > 0 && (b.state == circuitHalfOpen || b.failures >= b.threshold) {
+		b.open()
+	}
+}
+
+// trip opens the breaker at once, e.g. after a failed health probe.
+func (b *breaker) trip() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.open()
+}
+
+// open must be called with b.mu held.
+func (b *breaker) open() {
+	b.state = circuitOpen
+	b.since = time.Now()
+}
+
+// current returns the state of the breaker.
+func (b *breaker) current() circuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
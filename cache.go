@@ -1,16 +1,31 @@
-package main
+package mayflycache
 
 import (
 	"sync"
+	"time"
 
-	"github.com/hey-kong/mayflycache/lru"
+	"github.com/hey-kong/mayflycache/eviction"
 )
 
-// SafeCache is for concurrency control of LRU cache.
+// SafeCache is for concurrency control of the cache of an eviction policy.
 type SafeCache struct {
-	maxBytes int64
-	mu       sync.Mutex
-	lru      *lru.LRUCache
+	maxBytes  int64
+	mu        sync.Mutex
+	newPolicy eviction.NewFunc
+	policy    eviction.Policy
+	stop      chan struct{} // closed to stop the janitor
+}
+
+// NewSafeCache returns an LRU SafeCache that holds at most maxBytes,
+// 0 means no limit.
+func NewSafeCache(maxBytes int64) *SafeCache {
+	return NewSafeCacheWithPolicy(maxBytes, eviction.LRU)
+}
+
+// NewSafeCacheWithPolicy is like NewSafeCache, but the entries
+// are evicted by the policy created with newPolicy.
+func NewSafeCacheWithPolicy(maxBytes int64, newPolicy eviction.NewFunc) *SafeCache {
+	return &SafeCache{maxBytes: maxBytes, newPolicy: newPolicy}
 }
 
 // Get locks and unlocks when the it exits to ensure concurrency security.
@@ -18,22 +33,123 @@ func (c *SafeCache) Get(key string) (value Chunk, done bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.lru == nil {
+	if c.policy == nil {
 		return
 	}
-	if v, ok := c.lru.Get(key); ok {
+	if v, ok := c.policy.Get(key); ok {
 		value, done = v.(Chunk), ok
 	}
 	return
 }
 
-// Set locks and unlocks when the it exits to ensure concurrency security.
+// Set locks and unlocks when the it exits to ensure concurrency security,
+// the entry expires at the expiration of the chunk, or later if the
+// chunk is kept to be served stale.
 func (c *SafeCache) Set(key string, value Chunk) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.lru == nil {
-		c.lru = lru.NewLRUCache(c.maxBytes, nil)
+	if c.policy == nil {
+		c.policy = c.newPolicy(c.maxBytes, nil)
+	}
+	c.policy.SetWithExpire(key, value, value.keepUntil())
+}
+
+// Delete removes the key from the cache.
+func (c *SafeCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.policy == nil {
+		return
+	}
+	c.policy.Delete(key)
+}
+
+// Bytes returns the bytes of memory in use.
+func (c *SafeCache) Bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.policy == nil {
+		return 0
+	}
+	return c.policy.Bytes()
+}
+
+// Len returns how many entries are cached.
+func (c *SafeCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.policy == nil {
+		return 0
+	}
+	return c.policy.Len()
+}
+
+// Stats returns a snapshot of the cache counters.
+func (c *SafeCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.policy == nil {
+		return CacheStats{}
+	}
+	s := c.policy.Stats()
+	return CacheStats{
+		Bytes:       c.policy.Bytes(),
+		Items:       int64(c.policy.Len()),
+		Gets:        s.Gets,
+		Hits:        s.Hits,
+		Evictions:   s.Evictions,
+		Expirations: s.Expirations,
+	}
+}
+
+// RemoveExpired removes the expired entries to reclaim their bytes.
+func (c *SafeCache) RemoveExpired() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.policy == nil {
+		return 0
+	}
+	return c.policy.RemoveExpired()
+}
+
+// StartJanitor starts a background goroutine that removes
+// the expired entries every interval until StopJanitor is called.
+func (c *SafeCache) StartJanitor(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	c.stop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.RemoveExpired()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopJanitor stops the janitor started by StartJanitor.
+func (c *SafeCache) StopJanitor() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
 	}
-	c.lru.Set(key, value)
 }
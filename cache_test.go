@@ -0,0 +1,110 @@
+package mayflycache_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/hey-kong/mayflycache"
+	"github.com/hey-kong/mayflycache/eviction"
+)
+
+func TestShardedCache(t *testing.T) {
+	entryBytes := int64(len("key00") + len("v"))
+	c := mayflycache.NewShardedCache(100*entryBytes, 4, eviction.LRU)
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprintf("key%02d", i), mayflycache.NewChunk([]byte("v")))
+	}
+	if v, ok := c.Get("key42"); !ok || v.String() != "v" {
+		t.Fatalf("expect key42=v, but %v got", v)
+	}
+	c.Delete("key42")
+	if _, ok := c.Get("key42"); ok {
+		t.Fatalf("expect key42 to be deleted")
+	}
+
+	// Each shard holds a quarter of the bytes, so some keys
+	// are evicted unless they are spread evenly.
+	for i := 0; i < 1000; i++ {
+		c.Set(fmt.Sprintf("new%02d", i), mayflycache.NewChunk([]byte("v")))
+	}
+	if b := c.Bytes(); b > 100*entryBytes {
+		t.Fatalf("expect at most %d bytes, but %d got", 100*entryBytes, b)
+	}
+	s := c.Stats()
+	if s.Items != int64(c.Len()) || s.Bytes != c.Bytes() || s.Gets != 2 || s.Hits != 1 || s.Evictions == 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestShardedCacheSmall(t *testing.T) {
+	// A budget smaller than the number of shards still bounds the cache
+	c := mayflycache.NewShardedCache(3, 8, eviction.LRU)
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprintf("k%d", i), mayflycache.NewChunk(nil))
+	}
+	if b := c.Bytes(); b > 3 {
+		t.Fatalf("expect at most 3 bytes, but %d got", b)
+	}
+}
+
+func TestGroupShards(t *testing.T) {
+	loads := 0
+	g := mayflycache.NewGroup("sharded", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			loads++
+			return []byte(key), nil
+		},
+	), mayflycache.WithShards(8), mayflycache.WithHotCache(2<<10, 1))
+
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 10; j++ {
+			key := fmt.Sprintf("key%d", j)
+			if v, err := g.Get(context.Background(), key); err != nil || v.String() != key {
+				t.Fatalf("expect %s, but %v got", key, v)
+			}
+		}
+	}
+	if s := g.Stats(); loads != 10 || s.MainCache.Items != 10 {
+		t.Fatalf("expect 10 loads and items, but %d and %d got", loads, s.MainCache.Items)
+	}
+}
+
+// benchmarkCache runs 90% Get and 10% Set of 10000 keys on c in parallel,
+// run it with -cpu 1,2,4,8 to compare the throughput.
+func benchmarkCache(b *testing.B, c interface {
+	Get(key string) (mayflycache.Chunk, bool)
+	Set(key string, value mayflycache.Chunk)
+}) {
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		c.Set(keys[i], mayflycache.NewChunk([]byte("value")))
+	}
+	value := mayflycache.NewChunk([]byte("value"))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			key := keys[r.Intn(len(keys))]
+			if r.Intn(10) == 0 {
+				c.Set(key, value)
+			} else {
+				c.Get(key)
+			}
+		}
+	})
+}
+
+func BenchmarkSafeCache(b *testing.B) {
+	benchmarkCache(b, mayflycache.NewSafeCache(0))
+}
+
+func BenchmarkShardedCache(b *testing.B) {
+	for _, n := range []int{4, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", n), func(b *testing.B) {
+			benchmarkCache(b, mayflycache.NewShardedCache(0, n, eviction.LRU))
+		})
+	}
+}
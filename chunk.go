@@ -1,9 +1,13 @@
-package main
+package mayflycache
+
+import "time"
 
 // Chunk implements the Value interface, as the value
 // of the key-value entry in the cache, it's read-only.
 type Chunk struct {
 	b []byte
+	e time.Time // expiration, zero means never expires
+	k time.Time // when the cache drops the chunk if it is kept stale after e, zero means e
 }
 
 // NewChunk returns a new Chunk for a byte slice.
@@ -11,6 +15,25 @@ func NewChunk(b []byte) Chunk {
 	return Chunk{b: cloneBytes(b)}
 }
 
+// NewChunkWithExpire returns a new Chunk for a byte slice, which expires at e.
+func NewChunkWithExpire(b []byte, e time.Time) Chunk {
+	return Chunk{b: cloneBytes(b), e: e}
+}
+
+// Expire returns the expiration of the chunk,
+// the zero time means it never expires.
+func (c Chunk) Expire() time.Time {
+	return c.e
+}
+
+// keepUntil returns when the cache drops the chunk.
+func (c Chunk) keepUntil() time.Time {
+	if c.k.IsZero() {
+		return c.e
+	}
+	return c.k
+}
+
 // Size returns the length of the byte slice in the chunk.
 func (c Chunk) Size() int {
 	return len(c.b)
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hey-kong/mayflycache"
+	"github.com/hey-kong/mayflycache/swim"
+)
+
+var db = map[string]string{
+	"Name":  "Iggie Wang",
+	"Age":   "21",
+	"Hobby": "League of Legends",
+}
+
+func createGroup() *mayflycache.Group {
+	return mayflycache.NewGroup("info", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			log.Println("search key", key, "from db")
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s: %w", key, mayflycache.ErrNotFound)
+		},
+	), mayflycache.WithNegativeCache(1<<10, 10*time.Second), mayflycache.WithHotCache(1<<10, 0.1))
+}
+
+func startCacheServer(addr, gossipAddr, adminAddr string, seeds []string, group *mayflycache.Group) {
+	hp := mayflycache.NewHTTPPool(addr,
+		mayflycache.WithRequestTimeout(time.Second),
+		mayflycache.WithCircuitBreaker(3, 5*time.Second),
+		mayflycache.WithHealthCheck(2*time.Second),
+		mayflycache.WithReplication(2),
+		mayflycache.WithBoundedLoad(0.25),
+	)
+	group.RegisterPeers(hp)
+	startGossip(hp, gossipAddr, seeds)
+	go startAdminServer(adminAddr, hp)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", mayflycache.MetricsHandler())
+	mux.Handle("/", hp)
+	log.Println("CacheServer is running at", addr)
+	log.Fatal(http.ListenAndServe(addr[7:], mux))
+}
+
+// startAdminServer serves the admin endpoint on its own listener bound to
+// the loopback interface, as it is not authenticated, the peers and the
+// clients reaching the cache server can't change the membership.
+func startAdminServer(adminAddr string, hp *mayflycache.HTTPPool) {
+	mux := http.NewServeMux()
+	mux.Handle("/_mayflycache_admin/peers", hp.AdminHandler())
+	log.Println("Admin Server is running at", adminAddr)
+	log.Fatal(http.ListenAndServe(adminAddr, mux))
+}
+
+// startGossip discovers the peers by joining the cluster through the seeds,
+// it keeps retrying in background until one of the seeds answers.
+func startGossip(hp *mayflycache.HTTPPool, gossipAddr string, seeds []string) {
+	transport, err := swim.NewUDPTransport(gossipAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	node := hp.Gossip(swim.Config{Transport: transport})
+	go func() {
+		for {
+			err := node.Join(seeds...)
+			if err == nil {
+				return
+			}
+			log.Println("Failed to join the cluster:", err)
+			time.Sleep(time.Second)
+		}
+	}()
+}
+
+func startAPIServer(apiAddr string, group *mayflycache.Group) {
+	http.Handle("/api", http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			key := r.URL.Query().Get("key")
+			value, err := group.Get(r.Context(), key)
+			if errors.Is(err, mayflycache.ErrNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(value.ByteSlice())
+		},
+	))
+	log.Println("Frontend Server is running at", apiAddr)
+	log.Fatal(http.ListenAndServe(apiAddr[7:], nil))
+}
+
+func main() {
+	var port, gossipPort, adminPort int
+	var api bool
+	var seeds string
+	flag.IntVar(&port, "port", 8001, "CacheServer port")
+	flag.IntVar(&gossipPort, "gossip", 0, "Gossip port, CacheServer port + 1000 by default")
+	flag.IntVar(&adminPort, "admin", 0, "Admin port on the loopback interface, CacheServer port + 2000 by default")
+	flag.StringVar(&seeds, "seeds", "localhost:9001", "Comma-separated gossip addresses of the seeds")
+	flag.BoolVar(&api, "api", false, "Start a api server?")
+	flag.Parse()
+
+	if gossipPort == 0 {
+		gossipPort = port + 1000
+	}
+	if adminPort == 0 {
+		adminPort = port + 2000
+	}
+	addr := fmt.Sprintf("http://localhost:%d", port)
+	gossipAddr := fmt.Sprintf("localhost:%d", gossipPort)
+	adminAddr := fmt.Sprintf("127.0.0.1:%d", adminPort)
+
+	cache := createGroup()
+	if api {
+		apiAddr := "http://localhost:9999"
+		go startAPIServer(apiAddr, cache)
+	}
+	startCacheServer(addr, gossipAddr, adminAddr, strings.Split(seeds, ","), cache)
+}
@@ -0,0 +1,16 @@
+package consistenthash
+
+import "math"
+
+// BoundedLoad returns the capacity of each of the nodes by the consistent
+// hashing with bounded loads of Mirrokni et al., ceil((1+epsilon)*(total+1)/nodes),
+// where total is the current load of all the nodes and 1 is the new one.
+// A node at its capacity rejects the new load, which walks to the next
+// node in the order of the preference of the key, e.g. by GetFunc, so no
+// node takes more than 1+epsilon times the average even for hot keys.
+func BoundedLoad(total, nodes int, epsilon float64) int {
+	if nodes <= 0 {
+		return 0
+	}
+	return int(math.Ceil((1 + epsilon) * float64(total+1) / float64(nodes)))
+}
@@ -0,0 +1,51 @@
+package consistenthash
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBoundedLoad(t *testing.T) {
+	testCases := []struct {
+		total, nodes int
+		epsilon      float64
+		capacity     int
+	}{
+		{0, 4, 0.25, 1},
+		{3, 4, 0.25, 2},
+		{99, 10, 0.25, 13},
+		{99, 10, 0, 10},
+		{5, 0, 0.25, 0},
+	}
+	for _, c := range testCases {
+		if capacity := BoundedLoad(c.total, c.nodes, c.epsilon); capacity != c.capacity {
+			t.Errorf("expect the capacity %d for %d on %d nodes, but %d got", c.capacity, c.total, c.nodes, capacity)
+		}
+	}
+}
+
+func TestBoundedLoadHotKeys(t *testing.T) {
+	hash := New(50, nil)
+	for i := 0; i < 10; i++ {
+		hash.Set(fmt.Sprintf("http://10.0.0.%d:8001", i+1))
+	}
+
+	// All the loads of 3 hot keys stay in flight
+	const epsilon, total = 0.25, 1000
+	loads := make(map[string]int)
+	for i := 0; i < total; i++ {
+		capacity := BoundedLoad(i, 10, epsilon)
+		node := hash.GetFunc(fmt.Sprintf("hot%d", i%3), func(node string) bool {
+			return loads[node] < capacity
+		})
+		loads[node]++
+	}
+	for node, load := range loads {
+		if load > BoundedLoad(total-1, 10, epsilon) {
+			t.Errorf("expect at most %d loads on %s, but %d got", BoundedLoad(total-1, 10, epsilon), node, load)
+		}
+	}
+	if len(loads) < 8 {
+		t.Errorf("expect the hot keys to spread over the nodes, but %v got", loads)
+	}
+}
@@ -13,6 +13,7 @@ type Map struct {
 	replicas int            // record how many virtual nodes a real node corresponds to
 	keys     []int          // hash ring
 	hashMap  map[int]string // the mapping between virtual nodes and real nodes
+	weights  map[string]int // the virtual nodes of a real node are replicas times its weight
 }
 
 func New(replicas int, fn Hash) *Map {
@@ -20,6 +21,7 @@ func New(replicas int, fn Hash) *Map {
 		hash:     fn,
 		replicas: replicas,
 		hashMap:  make(map[int]string),
+		weights:  make(map[string]int),
 	}
 	if m.hash == nil {
 		m.hash = crc32.ChecksumIEEE
@@ -33,7 +35,41 @@ func (m *Map) Get(key string) string {
 	if len(m.keys) == 0 {
 		return ""
 	}
+	return m.hashMap[m.keys[m.search(key)]]
+}
 
+// GetFunc walks the hash ring from the key like Get, and returns the first
+// node accepted by accept, e.g. the first healthy one, or "" if none is.
+func (m *Map) GetFunc(key string, accept func(node string) bool) string {
+	if len(m.keys) == 0 {
+		return ""
+	}
+	idx := m.search(key)
+	var rejected map[string]bool
+	for i := 0; i < len(m.keys); i++ {
+		node := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if rejected[node] {
+			continue
+		}
+		if accept(node) {
+			return node
+		}
+		if rejected == nil {
+			rejected = make(map[string]bool)
+		}
+		rejected[node] = true
+	}
+	return ""
+}
+
+// GetN returns at most n distinct nodes walking the hash ring from the
+// key, the first one is returned by Get, the others are its successors.
+func (m *Map) GetN(key string, n int) []string {
+	return getN(m.GetFunc, key, n)
+}
+
+// search returns the index of the virtual node of the key in the hash ring.
+func (m *Map) search(key string) int {
 	hash := int(m.hash([]byte(key)))
 	// Find the first index greater than or equal to the hash in the hash ring
 	idx := sort.Search(len(m.keys), func(i int) bool {
@@ -42,17 +78,64 @@ func (m *Map) Get(key string) string {
 
 	// If not found, sort.Search will return len(m.keys),
 	// we need to set it to 0.
-	return m.hashMap[m.keys[idx%len(m.keys)]]
+	return idx % len(m.keys)
 }
 
 // Set adds virtual nodes to the hash ring.
 func (m *Map) Set(keys ...string) {
 	for _, key := range keys {
-		for i := 0; i < m.replicas; i++ {
+		m.set(key, 1)
+	}
+	sort.Ints(m.keys)
+}
+
+// SetWeighted adds virtual nodes to the hash ring in proportion to the
+// weights of the nodes, e.g. a node of weight 8 gets 8 times the keys of
+// a node of weight 1. A weight less than 1 is taken as 1.
+func (m *Map) SetWeighted(weights map[string]int) {
+	// Add the nodes in order, so the colliding virtual nodes are
+	// always taken by the same node.
+	for _, key := range sortedKeys(weights) {
+		m.set(key, weights[key])
+	}
+	sort.Ints(m.keys)
+}
+
+// set adds the virtual nodes of the key, the ring must be sorted after.
+func (m *Map) set(key string, weight int) {
+	weight = weightOf(weight)
+	m.weights[key] = weight
+	for i := 0; i < m.replicas*weight; i++ {
+		hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
+		m.keys = append(m.keys, hash)
+		m.hashMap[hash] = key
+	}
+}
+
+// Remove deletes the virtual nodes of the keys from the hash ring.
+func (m *Map) Remove(keys ...string) {
+	removed := false
+	for _, key := range keys {
+		for i := 0; i < m.replicas*m.weights[key]; i++ {
 			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
-			m.keys = append(m.keys, hash)
-			m.hashMap[hash] = key
+			// The virtual node may be taken by another node with the same hash
+			if m.hashMap[hash] == key {
+				delete(m.hashMap, hash)
+				removed = true
+			}
 		}
+		delete(m.weights, key)
 	}
-	sort.Ints(m.keys)
+	if !removed {
+		return
+	}
+
+	// Keep the hashes still mapping to real nodes, the ring is still sorted
+	ring := m.keys[:0]
+	for _, hash := range m.keys {
+		if _, ok := m.hashMap[hash]; ok {
+			ring = append(ring, hash)
+		}
+	}
+	m.keys = ring
 }
@@ -1,6 +1,8 @@
 package consistenthash
 
 import (
+	"crypto/sha1"
+	"encoding/binary"
 	"strconv"
 	"testing"
 )
@@ -32,3 +34,141 @@ func TestConsistentHash(t *testing.T) {
 		}
 	}
 }
+
+func TestRemove(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+
+	hash.Set("6", "4", "2")
+	hash.Remove("4")
+	testCases := map[string]string{
+		"2":  "2",
+		"11": "2",
+		"23": "6",
+		"27": "2",
+	}
+	for k, v := range testCases {
+		if hash.Get(k) != v {
+			t.Errorf("Asking for %s, should have yielded %s", k, v)
+		}
+	}
+	if len(hash.keys) != 6 || len(hash.hashMap) != 6 {
+		t.Errorf("expect 6 virtual nodes left, but %d got", len(hash.keys))
+	}
+
+	hash.Remove("6", "2")
+	if hash.Get("2") != "" {
+		t.Errorf("expect no node after removing all of them")
+	}
+}
+
+func TestGetFunc(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+
+	hash.Set("6", "4", "2")
+	down := map[string]bool{"4": true}
+	healthy := func(node string) bool { return !down[node] }
+	testCases := map[string]string{
+		"2":  "2",
+		"11": "2",
+		"23": "6",
+		"27": "2",
+	}
+	for k, v := range testCases {
+		if node := hash.GetFunc(k, healthy); node != v {
+			t.Errorf("Asking for %s, should have yielded %s, but %s got", k, v, node)
+		}
+	}
+
+	down["2"], down["6"] = true, true
+	if node := hash.GetFunc("11", healthy); node != "" {
+		t.Errorf("expect no node if all of them are rejected, but %s got", node)
+	}
+}
+
+func TestGetN(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+
+	hash.Set("6", "4", "2")
+	testCases := map[string][]string{
+		"2":  {"2", "4"},
+		"11": {"2", "4"},
+		"23": {"4", "6"},
+		"27": {"2", "4"},
+	}
+	for k, v := range testCases {
+		nodes := hash.GetN(k, 2)
+		if len(nodes) != 2 || nodes[0] != v[0] || nodes[1] != v[1] || nodes[0] != hash.Get(k) {
+			t.Errorf("Asking for %s, should have yielded %v, but %v got", k, v, nodes)
+		}
+	}
+
+	if nodes := hash.GetN("11", 5); len(nodes) != 3 {
+		t.Errorf("expect all the 3 nodes at most, but %v got", nodes)
+	}
+	if nodes := New(3, nil).GetN("11", 2); len(nodes) != 0 {
+		t.Errorf("expect no node in an empty ring, but %v got", nodes)
+	}
+}
+
+func TestSetWeighted(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+
+	// The node "2" of weight 2 gets the virtual nodes 2, 12, 22, 32, 42 and 52
+	hash.SetWeighted(map[string]int{"2": 2, "4": 1})
+	testCases := map[string]string{
+		"30": "2",
+		"45": "2",
+		"23": "4",
+	}
+	for k, v := range testCases {
+		if hash.Get(k) != v {
+			t.Errorf("Asking for %s, should have yielded %s", k, v)
+		}
+	}
+
+	hash.Remove("2")
+	if len(hash.keys) != 3 || hash.Get("45") != "4" {
+		t.Errorf("expect the virtual nodes of the weight to be removed, but %d left", len(hash.keys))
+	}
+}
+
+// sha1Hash mixes the similar names of the virtual nodes better than crc32,
+// so the distribution depends on the weights only.
+func sha1Hash(data []byte) uint32 {
+	sum := sha1.Sum(data)
+	return binary.BigEndian.Uint32(sum[:])
+}
+
+func TestWeightedDistribution(t *testing.T) {
+	hash := New(100, sha1Hash)
+	weights := map[string]int{"http://8gb:8001": 1, "http://16gb:8001": 2, "http://64gb:8001": 8}
+	hash.SetWeighted(weights)
+
+	const keys = 100000
+	counts := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		counts[hash.Get("key"+strconv.Itoa(i))]++
+	}
+	total := 0
+	for _, weight := range weights {
+		total += weight
+	}
+	for node, weight := range weights {
+		expected := float64(keys*weight) / float64(total)
+		if ratio := float64(counts[node]) / expected; ratio < 0.85 || ratio > 1.15 {
+			t.Errorf("expect about %.0f keys on %s, but %d got", expected, node, counts[node])
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package consistenthash
+
+// Jump places the keys by the jump consistent hash of Lamping and Veach,
+// it needs no memory but a bucket per weight unit of each node, and a
+// lookup costs O(log n) of arithmetic without any search.
+//
+// The buckets are numbered in the order of the names of the nodes, so
+// the nodes place the keys the same whatever order the peers are added
+// in, while adding or removing a node moves the keys of the nodes after
+// it as well, most of the keys if it is named before the others.
+type Jump struct {
+	buckets []string       // a node takes as many buckets as its weight
+	weights map[string]int // the weights of the nodes
+}
+
+// NewJump returns an empty Jump.
+func NewJump() *Jump {
+	return &Jump{weights: make(map[string]int)}
+}
+
+// jump returns the bucket of the key in [0, n).
+func jump(key uint64, n int) int {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// Get returns the node of the bucket of the key.
+func (j *Jump) Get(key string) string {
+	if len(j.buckets) == 0 {
+		return ""
+	}
+	return j.buckets[jump(hashString(key), len(j.buckets))]
+}
+
+// GetFunc tries the bucket of the key, and then the buckets of the key
+// rehashed with the attempt, which are spread over all the nodes.
+func (j *Jump) GetFunc(key string, accept func(node string) bool) string {
+	if len(j.buckets) == 0 {
+		return ""
+	}
+	h := hashString(key)
+	var rejected map[string]bool
+	try := func(node string) bool {
+		if rejected[node] {
+			return false
+		}
+		if accept(node) {
+			return true
+		}
+		if rejected == nil {
+			rejected = make(map[string]bool)
+		}
+		rejected[node] = true
+		return false
+	}
+	for i := 0; i < 2*len(j.buckets) && len(rejected) < len(j.weights); i++ {
+		if node := j.buckets[jump(h, len(j.buckets))]; try(node) {
+			return node
+		}
+		h = mix64(h + uint64(i) + 1)
+	}
+	// The unlucky attempts end with a walk over all the buckets
+	for _, node := range j.buckets {
+		if len(rejected) == len(j.weights) {
+			break
+		}
+		if try(node) {
+			return node
+		}
+	}
+	return ""
+}
+
+// GetN returns at most n distinct nodes in the order of GetFunc.
+func (j *Jump) GetN(key string, n int) []string {
+	return getN(j.GetFunc, key, n)
+}
+
+// Set adds the nodes and rebuilds the buckets.
+func (j *Jump) Set(keys ...string) {
+	for _, key := range keys {
+		j.weights[key] = 1
+	}
+	j.number()
+}
+
+// SetWeighted adds the nodes and rebuilds the buckets, a node takes
+// as many buckets as its weight.
+func (j *Jump) SetWeighted(weights map[string]int) {
+	for key, weight := range weights {
+		j.weights[key] = weightOf(weight)
+	}
+	j.number()
+}
+
+// Remove removes the nodes and rebuilds the buckets.
+func (j *Jump) Remove(keys ...string) {
+	for _, key := range keys {
+		delete(j.weights, key)
+	}
+	j.number()
+}
+
+// number rebuilds the buckets of the nodes in the order of their names.
+func (j *Jump) number() {
+	j.buckets = j.buckets[:0]
+	for _, node := range sortedKeys(j.weights) {
+		for i := 0; i < j.weights[node]; i++ {
+			j.buckets = append(j.buckets, node)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package consistenthash
+
+// defaultMaglevSize is the size of the lookup table of Maglev, a prime
+// much larger than the number of the nodes.
+const defaultMaglevSize = 65537
+
+// Maglev places the keys by the lookup table of Google's Maglev load
+// balancer, the nodes take turns to fill the table by their own
+// permutations of it, so they get almost equal shares of the entries.
+// A lookup costs O(1), and the table is rebuilt on each change, which
+// moves a few keys between the remaining nodes too.
+type Maglev struct {
+	size    int            // size of the table, a prime
+	nodes   []string       // sorted
+	weights map[string]int // the weights of the nodes
+	table   []int          // indexes of the nodes in nodes
+}
+
+// NewMaglev returns an empty Maglev with the lookup table of size, which
+// is rounded up to a prime, so every permutation visits all the entries;
+// less than 2 means defaultMaglevSize.
+func NewMaglev(size int) *Maglev {
+	if size < 2 {
+		size = defaultMaglevSize
+	}
+	return &Maglev{
+		size:    nextPrime(size),
+		weights: make(map[string]int),
+	}
+}
+
+// Get returns the node of the entry of the key.
+func (m *Maglev) Get(key string) string {
+	if len(m.table) == 0 {
+		return ""
+	}
+	return m.nodes[m.table[hashString(key)%uint64(m.size)]]
+}
+
+// GetFunc walks the table from the entry of the key, the entries next
+// to each other are taken by the nodes in random order.
+func (m *Maglev) GetFunc(key string, accept func(node string) bool) string {
+	if len(m.table) == 0 {
+		return ""
+	}
+	idx := int(hashString(key) % uint64(m.size))
+	var rejected map[string]bool
+	for i := 0; i < m.size && len(rejected) < len(m.nodes); i++ {
+		node := m.nodes[m.table[(idx+i)%m.size]]
+		if rejected[node] {
+			continue
+		}
+		if accept(node) {
+			return node
+		}
+		if rejected == nil {
+			rejected = make(map[string]bool)
+		}
+		rejected[node] = true
+	}
+	return ""
+}
+
+// GetN returns at most n distinct nodes in the order of GetFunc.
+func (m *Maglev) GetN(key string, n int) []string {
+	return getN(m.GetFunc, key, n)
+}
+
+// Set adds the nodes and rebuilds the table.
+func (m *Maglev) Set(keys ...string) {
+	for _, key := range keys {
+		m.weights[key] = 1
+	}
+	m.populate()
+}
+
+// SetWeighted adds the nodes and rebuilds the table, a node fills
+// as many entries as its weight in each turn.
+func (m *Maglev) SetWeighted(weights map[string]int) {
+	for key, weight := range weights {
+		m.weights[key] = weightOf(weight)
+	}
+	m.populate()
+}
+
+// Remove removes the nodes and rebuilds the table.
+func (m *Maglev) Remove(keys ...string) {
+	for _, key := range keys {
+		delete(m.weights, key)
+	}
+	m.populate()
+}
+
+// populate rebuilds the table, each node fills the next empty entry in
+// its permutation of the table, which is given by the offset and skip
+// derived from the name of the node.
+func (m *Maglev) populate() {
+	m.nodes = sortedKeys(m.weights)
+	if len(m.nodes) == 0 {
+		m.table = nil
+		return
+	}
+
+	size := uint64(m.size)
+	offsets := make([]uint64, len(m.nodes))
+	skips := make([]uint64, len(m.nodes))
+	for i, node := range m.nodes {
+		h := hashString(node)
+		offsets[i] = h % size
+		skips[i] = mix64(h)%(size-1) + 1
+	}
+
+	table := make([]int, m.size)
+	for i := range table {
+		table[i] = -1
+	}
+	next := make([]uint64, len(m.nodes))
+	for filled := 0; ; {
+		for i, node := range m.nodes {
+			for w := 0; w < m.weights[node]; w++ {
+				c := (offsets[i] + next[i]*skips[i]) % size
+				for table[c] >= 0 {
+					next[i]++
+					c = (offsets[i] + next[i]*skips[i]) % size
+				}
+				table[c] = i
+				next[i]++
+				if filled++; filled == m.size {
+					m.table = table
+					return
+				}
+			}
+		}
+	}
+}
+
+// nextPrime returns the smallest prime not less than n, which is at least 2.
+func nextPrime(n int) int {
+	for ; ; n++ {
+		prime := true
+		for d := 2; d*d <= n; d++ {
+			if n%d == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			return n
+		}
+	}
+}
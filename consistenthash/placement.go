@@ -0,0 +1,83 @@
+package consistenthash
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+// A Placement places the keys on the nodes, so that only a small part of
+// the keys move when the nodes are added or removed. Map is the hash ring,
+// Jump, Rendezvous and Maglev are the alternatives.
+type Placement interface {
+	// Get returns the node of the key, or "" if there is no node.
+	Get(key string) string
+	// GetFunc returns the first node accepted by accept in the order of
+	// the preference of the key, the first one is returned by Get.
+	GetFunc(key string, accept func(node string) bool) string
+	// GetN returns at most n distinct nodes in the order of the preference of the key.
+	GetN(key string, n int) []string
+	// Set adds the nodes of weight 1.
+	Set(keys ...string)
+	// SetWeighted adds the nodes, each of them gets the keys in
+	// proportion to its weight, a weight less than 1 is taken as 1.
+	SetWeighted(weights map[string]int)
+	// Remove removes the nodes, their keys move to the other nodes.
+	Remove(keys ...string)
+}
+
+var (
+	_ Placement = (*Map)(nil)
+	_ Placement = (*Jump)(nil)
+	_ Placement = (*Rendezvous)(nil)
+	_ Placement = (*Maglev)(nil)
+)
+
+// getN implements GetN with the GetFunc of a Placement.
+func getN(getFunc func(string, func(string) bool) string, key string, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	nodes := make([]string, 0, n)
+	getFunc(key, func(node string) bool {
+		nodes = append(nodes, node)
+		return len(nodes) == n
+	})
+	return nodes
+}
+
+// hashString returns the 64-bit hash of s.
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return mix64(h.Sum64())
+}
+
+// mix64 is the finalizer of SplitMix64, it spreads the similar
+// hashes, e.g. of the names differing in one byte, over 64 bits.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// weightOf returns the weight taken for w.
+func weightOf(w int) int {
+	if w < 1 {
+		return 1
+	}
+	return w
+}
+
+// sortedKeys returns the nodes in weights sorted, so they are added in
+// the same order on every node.
+func sortedKeys(weights map[string]int) []string {
+	keys := make([]string, 0, len(weights))
+	for key := range weights {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,217 @@
+package consistenthash
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// placements are the Placements to compare, Ring is the default of HTTPPool.
+var placements = map[string]func() Placement{
+	"Ring":       func() Placement { return New(50, nil) },
+	"Jump":       func() Placement { return NewJump() },
+	"Rendezvous": func() Placement { return NewRendezvous() },
+	"Maglev":     func() Placement { return NewMaglev(0) },
+}
+
+var placementNames = []string{"Ring", "Jump", "Rendezvous", "Maglev"}
+
+func TestPlacement(t *testing.T) {
+	for _, name := range placementNames {
+		p := placements[name]()
+		if p.Get("key") != "" || len(p.GetN("key", 2)) != 0 {
+			t.Fatalf("%s: expect no node in an empty placement", name)
+		}
+
+		p.Set("a", "b", "c")
+		for i := 0; i < 100; i++ {
+			key := "key" + strconv.Itoa(i)
+			nodes := p.GetN(key, 3)
+			if len(nodes) != 3 || nodes[0] == nodes[1] || nodes[1] == nodes[2] || nodes[0] == nodes[2] {
+				t.Fatalf("%s: expect 3 distinct nodes of %s, but %v got", name, key, nodes)
+			}
+			if nodes[0] != p.Get(key) {
+				t.Fatalf("%s: expect %s to be the first node of %s, but %v got", name, p.Get(key), key, nodes)
+			}
+			if node := p.GetFunc(key, func(node string) bool { return node != nodes[0] }); node != nodes[1] {
+				t.Fatalf("%s: expect %s to be the next node of %s, but %s got", name, nodes[1], key, node)
+			}
+			if node := p.GetFunc(key, func(string) bool { return false }); node != "" {
+				t.Fatalf("%s: expect no node if all of them are rejected, but %s got", name, node)
+			}
+		}
+
+		p.Remove("b")
+		for i := 0; i < 100; i++ {
+			if node := p.Get("key" + strconv.Itoa(i)); node != "a" && node != "c" {
+				t.Fatalf("%s: expect b to be removed, but %s got", name, node)
+			}
+		}
+		p.Remove("a", "c")
+		if p.Get("key") != "" {
+			t.Fatalf("%s: expect no node after removing all of them", name)
+		}
+	}
+}
+
+func TestPlacementOrder(t *testing.T) {
+	for _, name := range placementNames {
+		p, q := placements[name](), placements[name]()
+		p.Set("a", "b", "c", "d")
+		p.Remove("b")
+		p.SetWeighted(map[string]int{"a": 2})
+
+		// The same nodes added and removed in another order
+		q.Set("b")
+		q.SetWeighted(map[string]int{"a": 2})
+		q.Set("d")
+		q.Remove("b")
+		q.Set("c")
+		for i := 0; i < 1000; i++ {
+			key := "key" + strconv.Itoa(i)
+			if p.Get(key) != q.Get(key) {
+				t.Fatalf("%s: expect the same node of %s, but %s and %s got", name, key, p.Get(key), q.Get(key))
+			}
+		}
+	}
+}
+
+func TestMaglevSize(t *testing.T) {
+	for size, prime := range map[int]int{2: 2, 100: 101, 65536: 65537, 65537: 65537} {
+		if m := NewMaglev(size); m.size != prime {
+			t.Fatalf("expect the size %d to be rounded up to %d, but %d got", size, prime, m.size)
+		}
+	}
+
+	// A size sharing a factor with the skip of a node would never be filled
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m := NewMaglev(100)
+		m.Set("a", "b", "c", "d", "e")
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expect the table of size 100 to be populated")
+	}
+}
+
+func TestPlacementWeights(t *testing.T) {
+	weights := map[string]int{"http://8gb:8001": 1, "http://16gb:8001": 2, "http://64gb:8001": 8}
+	for _, name := range placementNames {
+		p := placements[name]()
+		if name == "Ring" {
+			p = New(100, sha1Hash)
+		}
+		p.SetWeighted(weights)
+		counts := distribute(p, 100000)
+		for node, weight := range weights {
+			expected := float64(100000*weight) / 11
+			if ratio := float64(counts[node]) / expected; ratio < 0.85 || ratio > 1.15 {
+				t.Errorf("%s: expect about %.0f keys on %s, but %d got", name, expected, node, counts[node])
+			}
+		}
+	}
+}
+
+// distribute counts the keys placed on each node.
+func distribute(p Placement, keys int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		counts[p.Get("key"+strconv.Itoa(i))]++
+	}
+	return counts
+}
+
+// placementReport measures the balance of the keys on n nodes, the
+// maximum load divided by the average, and the fractions of the keys
+// moved when a node is added and when one is removed.
+func placementReport(newPlacement func() Placement, n, keys int) (imbalance, addMoved, removeMoved float64) {
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("http://10.0.0.%d:8001", i+1)
+	}
+	p := newPlacement()
+	p.Set(nodes...)
+	before := make([]string, keys)
+	max := 0
+	counts := make(map[string]int)
+	for i := range before {
+		before[i] = p.Get("key" + strconv.Itoa(i))
+		if counts[before[i]]++; counts[before[i]] > max {
+			max = counts[before[i]]
+		}
+	}
+	imbalance = float64(max) / (float64(keys) / float64(n))
+
+	moved := func(p Placement) float64 {
+		m := 0
+		for i := range before {
+			if p.Get("key"+strconv.Itoa(i)) != before[i] {
+				m++
+			}
+		}
+		return float64(m) / float64(keys)
+	}
+	p.Set(fmt.Sprintf("http://10.0.0.%d:8001", n+1))
+	addMoved = moved(p)
+
+	p = newPlacement()
+	p.Set(nodes...)
+	p.Remove(nodes[n/2])
+	removeMoved = moved(p)
+	return
+}
+
+// TestPlacementReport reports the balance and the key movement of the
+// placements, run it with -v to see them. The ideal movement is 1/(n+1)
+// of the keys for an added node and 1/n for a removed one.
+func TestPlacementReport(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the report in short mode")
+	}
+	const n, keys = 10, 100000
+	for _, name := range placementNames {
+		imbalance, addMoved, removeMoved := placementReport(placements[name], n, keys)
+		t.Logf("%-10s imbalance %.3f moved on add %.3f (ideal %.3f) on remove %.3f (ideal %.3f)",
+			name, imbalance, addMoved, 1.0/(n+1), removeMoved, 1.0/n)
+		if name != "Ring" && imbalance > 1.1 {
+			t.Errorf("%s: expect the keys to be balanced, but the imbalance %.3f got", name, imbalance)
+		}
+		// The buckets of Jump are numbered in the order of the names, so
+		// the node added before the others moves most of the keys
+		if name != "Jump" && addMoved > 1.5/(n+1) {
+			t.Errorf("%s: expect about 1/%d of the keys to move on add, but %.3f got", name, n+1, addMoved)
+		}
+	}
+}
+
+// BenchmarkPlacement measures the lookups of the placements, the balance
+// and key movement are reported as the imbalance, add-moved and
+// remove-moved metrics.
+func BenchmarkPlacement(b *testing.B) {
+	for _, n := range []int{10, 100} {
+		for _, name := range placementNames {
+			b.Run(fmt.Sprintf("%s/%d", name, n), func(b *testing.B) {
+				imbalance, addMoved, removeMoved := placementReport(placements[name], n, 100000)
+				p := placements[name]()
+				for i := 0; i < n; i++ {
+					p.Set(fmt.Sprintf("http://10.0.0.%d:8001", i+1))
+				}
+				keys := make([]string, 1024)
+				for i := range keys {
+					keys[i] = "key" + strconv.Itoa(i)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					p.Get(keys[i%len(keys)])
+				}
+				b.ReportMetric(imbalance, "imbalance")
+				b.ReportMetric(addMoved, "add-moved")
+				b.ReportMetric(removeMoved, "remove-moved")
+			})
+		}
+	}
+}
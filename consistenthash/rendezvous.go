@@ -0,0 +1,111 @@
+package consistenthash
+
+import (
+	"math"
+	"sort"
+)
+
+// Rendezvous places the keys by the rendezvous (highest random weight)
+// hashing, each key goes to the node of the highest score of the pair.
+// A lookup costs O(n) for n nodes, and only the keys of the removed
+// node move.
+type Rendezvous struct {
+	nodes []rendezvousNode // sorted by name
+}
+
+type rendezvousNode struct {
+	name   string
+	hash   uint64
+	weight float64
+}
+
+// NewRendezvous returns an empty Rendezvous.
+func NewRendezvous() *Rendezvous {
+	return &Rendezvous{}
+}
+
+// score is the weighted score of the node for the key hash, the scores
+// of the node of weight w win w times as often as those of weight 1.
+func (n *rendezvousNode) score(key uint64) float64 {
+	// u is uniform in (0, 1)
+	u := (float64(mix64(key^n.hash)>>11) + 0.5) / (1 << 53)
+	return -n.weight / math.Log(u)
+}
+
+// Get returns the node of the highest score for the key.
+func (r *Rendezvous) Get(key string) string {
+	h := hashString(key)
+	best, bestScore := "", 0.0
+	for i := range r.nodes {
+		if s := r.nodes[i].score(h); best == "" || s > bestScore {
+			best, bestScore = r.nodes[i].name, s
+		}
+	}
+	return best
+}
+
+// GetFunc tries the nodes in the descending order of the scores.
+func (r *Rendezvous) GetFunc(key string, accept func(node string) bool) string {
+	// The best node is usually accepted without sorting the others
+	best := r.Get(key)
+	if best == "" || accept(best) {
+		return best
+	}
+	h := hashString(key)
+	scores := make(map[string]float64, len(r.nodes))
+	names := make([]string, 0, len(r.nodes))
+	for i := range r.nodes {
+		scores[r.nodes[i].name] = r.nodes[i].score(h)
+		names = append(names, r.nodes[i].name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return scores[names[i]] > scores[names[j]]
+	})
+	for _, node := range names {
+		if node != best && accept(node) {
+			return node
+		}
+	}
+	return ""
+}
+
+// GetN returns the n nodes of the highest scores.
+func (r *Rendezvous) GetN(key string, n int) []string {
+	return getN(r.GetFunc, key, n)
+}
+
+// Set adds the nodes.
+func (r *Rendezvous) Set(keys ...string) {
+	for _, key := range keys {
+		r.set(key, 1)
+	}
+}
+
+// SetWeighted adds the nodes, their scores are scaled by the weights.
+func (r *Rendezvous) SetWeighted(weights map[string]int) {
+	for _, key := range sortedKeys(weights) {
+		r.set(key, weights[key])
+	}
+}
+
+func (r *Rendezvous) set(key string, weight int) {
+	node := rendezvousNode{name: key, hash: hashString(key), weight: float64(weightOf(weight))}
+	i := sort.Search(len(r.nodes), func(i int) bool { return r.nodes[i].name >= key })
+	if i < len(r.nodes) && r.nodes[i].name == key {
+		r.nodes[i] = node
+		return
+	}
+	r.nodes = append(r.nodes, rendezvousNode{})
+	copy(r.nodes[i+1:], r.nodes[i:])
+	r.nodes[i] = node
+}
+
+// Remove removes the nodes.
+func (r *Rendezvous) Remove(keys ...string) {
+	for _, key := range keys {
+		i := sort.Search(len(r.nodes), func(i int) bool { return r.nodes[i].name >= key })
+		if i < len(r.nodes) && r.nodes[i].name == key {
+			r.nodes = append(r.nodes[:i], r.nodes[i+1:]...)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package eviction
+
+import (
+	"container/list"
+	"time"
+)
+
+// ARCCache is the adaptive replacement cache, it splits the memory between
+// the entries used once (t1) and the ones used more than once (t2), and
+// adapts the split according to the hits of the recently evicted keys
+// remembered in the ghost lists (b1 and b2).
+type ARCCache struct {
+	maxBytes  int64 // maximum bytes of memory available
+	p         int64 // target bytes of t1
+	t1, t2    *entryList
+	b1, b2    *entryList               // ghost entries evicted from t1 and t2
+	m         map[string]*list.Element // map key to the element in any of the lists
+	onEvicted func(string, Value)      // optional func, called when an entry is removed
+	stats     Stats
+}
+
+// NewARCCache returns an ARCCache holding at most maxBytes, 0 means no limit.
+func NewARCCache(maxBytes int64, onEvicted func(string, Value)) *ARCCache {
+	return &ARCCache{
+		maxBytes:  maxBytes,
+		t1:        newEntryList(),
+		t2:        newEntryList(),
+		b1:        newEntryList(),
+		b2:        newEntryList(),
+		m:         make(map[string]*list.Element),
+		onEvicted: onEvicted,
+	}
+}
+
+// Get implements Policy interface function.
+func (c *ARCCache) Get(key string) (value Value, done bool) {
+	c.stats.Gets++
+	elem, ok := c.m[key]
+	if !ok || !c.resident(elem) {
+		return
+	}
+	e := elem.Value.(*entry)
+	if e.expired(time.Now()) {
+		c.removeElement(elem)
+		c.stats.Expirations++
+		return
+	}
+	c.m[key] = c.move(elem, c.t2)
+	value, done = e.value, true
+	c.stats.Hits++
+	return
+}
+
+// SetWithExpire implements Policy interface function.
+func (c *ARCCache) SetWithExpire(key string, value Value, expire time.Time) {
+	elem, ok := c.m[key]
+	if ok && c.resident(elem) {
+		elem.Value.(*entry).list.resize(elem, value, expire)
+		c.m[key] = c.move(elem, c.t2)
+		c.replace(0, false)
+		return
+	}
+
+	e := newEntry(key, value, expire)
+	target, inB2 := c.t1, false
+	if ok {
+		// The key was evicted recently, adapt the target of t1
+		// towards the list it was evicted from.
+		ghost := elem.Value.(*entry).list
+		delta := e.size
+		if ghost == c.b1 {
+			if c.b1.bytes > 0 && c.b1.bytes < c.b2.bytes {
+				delta = e.size * c.b2.bytes / c.b1.bytes
+			}
+			c.p = min(c.p+delta, c.maxBytes)
+		} else {
+			if c.b2.bytes > 0 && c.b2.bytes < c.b1.bytes {
+				delta = e.size * c.b1.bytes / c.b2.bytes
+			}
+			c.p = max(c.p-delta, 0)
+			inB2 = true
+		}
+		ghost.remove(elem)
+		delete(c.m, key)
+		target = c.t2
+	}
+
+	c.replace(e.size, inB2)
+	c.m[key] = target.pushBack(e)
+	c.replace(0, inB2)
+	c.trimGhosts()
+}
+
+// Delete implements Policy interface function.
+func (c *ARCCache) Delete(key string) bool {
+	elem, ok := c.m[key]
+	if !ok {
+		return false
+	}
+	if !c.resident(elem) {
+		elem.Value.(*entry).list.remove(elem)
+		delete(c.m, key)
+		return false
+	}
+	c.removeElement(elem)
+	return true
+}
+
+// RemoveExpired implements Policy interface function.
+func (c *ARCCache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for _, el := range []*entryList{c.t1, c.t2} {
+		for elem := el.l.Front(); elem != nil; {
+			next := elem.Next()
+			if elem.Value.(*entry).expired(now) {
+				c.removeElement(elem)
+				n++
+			}
+			elem = next
+		}
+	}
+	c.stats.Expirations += int64(n)
+	return n
+}
+
+// Bytes returns the bytes of memory in use, the ghost entries are not counted.
+func (c *ARCCache) Bytes() int64 {
+	return c.t1.bytes + c.t2.bytes
+}
+
+// Len returns how many entries are cached.
+func (c *ARCCache) Len() int {
+	return c.t1.len() + c.t2.len()
+}
+
+// Stats returns the counters of the cache operations.
+func (c *ARCCache) Stats() Stats {
+	return c.stats
+}
+
+func (c *ARCCache) resident(elem *list.Element) bool {
+	el := elem.Value.(*entry).list
+	return el == c.t1 || el == c.t2
+}
+
+func (c *ARCCache) move(elem *list.Element, to *entryList) *list.Element {
+	e := elem.Value.(*entry).list.remove(elem)
+	return to.pushBack(e)
+}
+
+// replace evicts the entries until there is room for size more bytes,
+// an entry of t1 is evicted if t1 is larger than its target.
+func (c *ARCCache) replace(size int64, inB2 bool) {
+	for c.maxBytes != 0 && c.maxBytes < c.Bytes()+size && c.Len() > 0 {
+		if c.t1.len() > 0 && (c.t1.bytes > c.p || (inB2 && c.t1.bytes == c.p) || c.t2.len() == 0) {
+			c.evict(c.t1, c.b1)
+		} else {
+			c.evict(c.t2, c.b2)
+		}
+	}
+}
+
+// evict moves the oldest entry of from to the ghost list.
+func (c *ARCCache) evict(from, ghost *entryList) {
+	e := from.remove(from.l.Front())
+	if c.onEvicted != nil {
+		c.onEvicted(e.key, e.value)
+	}
+	e.value = nil
+	c.m[e.key] = ghost.pushBack(e)
+	c.stats.Evictions++
+}
+
+// trimGhosts bounds t1+b1 by maxBytes and all the lists by 2*maxBytes.
+func (c *ARCCache) trimGhosts() {
+	if c.maxBytes == 0 {
+		return
+	}
+	for c.t1.bytes+c.b1.bytes > c.maxBytes && c.b1.len() > 0 {
+		delete(c.m, c.b1.remove(c.b1.l.Front()).key)
+	}
+	for c.Bytes()+c.b1.bytes+c.b2.bytes > 2*c.maxBytes && c.b2.len() > 0 {
+		delete(c.m, c.b2.remove(c.b2.l.Front()).key)
+	}
+}
+
+func (c *ARCCache) removeElement(elem *list.Element) {
+	e := elem.Value.(*entry).list.remove(elem)
+	delete(c.m, e.key)
+	if c.onEvicted != nil {
+		c.onEvicted(e.key, e.value)
+	}
+}
+
+func min(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
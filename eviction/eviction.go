@@ -0,0 +1,124 @@
+// Package eviction provides the cache algorithms that decide which entries
+// are evicted when the cache is full, e.g. LRU, LFU, ARC, 2Q and W-TinyLFU.
+package eviction
+
+import (
+	"container/list"
+	"time"
+
+	"github.com/hey-kong/mayflycache/lru"
+)
+
+// Value is the cached value, its Size is counted in the bytes of the cache.
+type Value = lru.Value
+
+// Stats are the counters of the cache operations.
+type Stats = lru.Stats
+
+// A Policy is a cache bounded by the bytes of its keys and values,
+// it is not safe for concurrent use.
+type Policy interface {
+	// Get returns the value of the key, the expired entry is treated as missing.
+	Get(key string) (Value, bool)
+	// SetWithExpire adds the value, the zero expire means it never expires.
+	SetWithExpire(key string, value Value, expire time.Time)
+	// Delete removes the entry of the key, it reports whether the key was cached.
+	Delete(key string) bool
+	// RemoveExpired removes all the expired entries and returns how many of them are removed.
+	RemoveExpired() int
+	Bytes() int64
+	Len() int
+	Stats() Stats
+}
+
+// A NewFunc creates a Policy holding at most maxBytes, 0 means no limit.
+// The optional onEvicted is called when an entry is removed from the cache.
+type NewFunc func(maxBytes int64, onEvicted func(string, Value)) Policy
+
+// LRU is the NewFunc of the least recently used policy.
+func LRU(maxBytes int64, onEvicted func(string, Value)) Policy {
+	return lru.NewLRUCache(maxBytes, onEvicted)
+}
+
+// LFU is the NewFunc of the least frequently used policy.
+func LFU(maxBytes int64, onEvicted func(string, Value)) Policy {
+	return NewLFUCache(maxBytes, onEvicted)
+}
+
+// ARC is the NewFunc of the adaptive replacement cache policy.
+func ARC(maxBytes int64, onEvicted func(string, Value)) Policy {
+	return NewARCCache(maxBytes, onEvicted)
+}
+
+// TwoQ is the NewFunc of the 2Q policy.
+func TwoQ(maxBytes int64, onEvicted func(string, Value)) Policy {
+	return NewTwoQCache(maxBytes, onEvicted)
+}
+
+// TinyLFU is the NewFunc of the W-TinyLFU policy.
+func TinyLFU(maxBytes int64, onEvicted func(string, Value)) Policy {
+	return NewTinyLFUCache(maxBytes, onEvicted)
+}
+
+// entry is the element of the lists in the policies, a ghost entry
+// only remembers the key and the size of an evicted one.
+type entry struct {
+	key    string
+	value  Value
+	expire time.Time // zero means the entry never expires
+	size   int64
+	freq   int        // used by LFU
+	list   *entryList // the list holding the entry
+}
+
+func newEntry(key string, value Value, expire time.Time) *entry {
+	e := &entry{key: key}
+	e.update(value, expire)
+	return e
+}
+
+func (e *entry) update(value Value, expire time.Time) {
+	e.value = value
+	e.expire = expire
+	e.size = int64(len(e.key)) + int64(value.Size())
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+// entryList is a list of entries that counts their bytes,
+// the front of the list is the oldest one.
+type entryList struct {
+	l     *list.List
+	bytes int64
+}
+
+func newEntryList() *entryList {
+	return &entryList{l: list.New()}
+}
+
+func (el *entryList) pushBack(e *entry) *list.Element {
+	e.list = el
+	el.bytes += e.size
+	return el.l.PushBack(e)
+}
+
+func (el *entryList) remove(elem *list.Element) *entry {
+	e := el.l.Remove(elem).(*entry)
+	el.bytes -= e.size
+	e.list = nil
+	return e
+}
+
+// resize updates the size of the entry after its value is changed.
+func (el *entryList) resize(elem *list.Element, value Value, expire time.Time) {
+	e := elem.Value.(*entry)
+	el.bytes -= e.size
+	e.update(value, expire)
+	el.bytes += e.size
+}
+
+func (el *entryList) len() int {
+	return el.l.Len()
+}
@@ -0,0 +1,194 @@
+package eviction
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (s String) Size() int {
+	return len(s)
+}
+
+var policies = map[string]NewFunc{
+	"LRU":     LRU,
+	"LFU":     LFU,
+	"ARC":     ARC,
+	"TwoQ":    TwoQ,
+	"TinyLFU": TinyLFU,
+}
+
+func TestGetSet(t *testing.T) {
+	for name, newPolicy := range policies {
+		t.Run(name, func(t *testing.T) {
+			c := newPolicy(0, nil)
+			c.SetWithExpire("key1", String("1234"), time.Time{})
+			if v, ok := c.Get("key1"); !ok || string(v.(String)) != "1234" {
+				t.Fatalf("cache hit key1=1234 failed")
+			}
+			if _, ok := c.Get("key2"); ok {
+				t.Fatalf("cache miss key2 failed")
+			}
+
+			c.SetWithExpire("key1", String("1"), time.Time{})
+			if v, ok := c.Get("key1"); !ok || string(v.(String)) != "1" {
+				t.Fatalf("expect key1 updated to 1, but %v got", v)
+			}
+			if c.Bytes() != int64(len("key1")+len("1")) || c.Len() != 1 {
+				t.Fatalf("expect %d bytes, but %d got", len("key1")+len("1"), c.Bytes())
+			}
+			if s := c.Stats(); s.Gets != 3 || s.Hits != 2 {
+				t.Fatalf("expect 3 gets and 2 hits, but %+v got", s)
+			}
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	for name, newPolicy := range policies {
+		t.Run(name, func(t *testing.T) {
+			var removed []string
+			c := newPolicy(0, func(key string, _ Value) { removed = append(removed, key) })
+			c.SetWithExpire("key1", String("1234"), time.Time{})
+			c.SetWithExpire("key2", String("1234"), time.Time{})
+
+			if !c.Delete("key1") || c.Delete("key3") {
+				t.Fatalf("Delete should report whether the key was cached")
+			}
+			if _, ok := c.Get("key1"); ok || c.Len() != 1 || c.Bytes() != int64(len("key2")+len("1234")) {
+				t.Fatalf("Delete key1 failed")
+			}
+			if len(removed) != 1 || removed[0] != "key1" {
+				t.Fatalf("expect the callback of key1, but %v got", removed)
+			}
+		})
+	}
+}
+
+func TestExpire(t *testing.T) {
+	for name, newPolicy := range policies {
+		t.Run(name, func(t *testing.T) {
+			c := newPolicy(0, nil)
+			c.SetWithExpire("key1", String("1"), time.Now().Add(-time.Second))
+			c.SetWithExpire("key2", String("2"), time.Now().Add(-time.Second))
+			c.SetWithExpire("key3", String("3"), time.Now().Add(time.Hour))
+
+			if _, ok := c.Get("key1"); ok {
+				t.Fatalf("expired key1 should not be returned")
+			}
+			if n := c.RemoveExpired(); n != 1 {
+				t.Fatalf("expect 1 expired entry removed, but %d got", n)
+			}
+			if c.Len() != 1 || c.Bytes() != int64(len("key3")+1) {
+				t.Fatalf("expect only key3 left, but %d entries of %d bytes got", c.Len(), c.Bytes())
+			}
+			if s := c.Stats(); s.Expirations != 2 {
+				t.Fatalf("expect 2 expirations, but %d got", s.Expirations)
+			}
+		})
+	}
+}
+
+// TestAccounting checks the bytes and the eviction callbacks
+// against a model of the cached keys after random operations.
+func TestAccounting(t *testing.T) {
+	for name, newPolicy := range policies {
+		t.Run(name, func(t *testing.T) {
+			const maxBytes = 200
+			cached := make(map[string]int64)
+			evicted := int64(0)
+			c := newPolicy(maxBytes, func(key string, value Value) {
+				if _, ok := cached[key]; !ok {
+					t.Fatalf("callback of %s which is not cached", key)
+				}
+				delete(cached, key)
+				evicted++
+			})
+
+			r := rand.New(rand.NewSource(1))
+			deleted := int64(0)
+			for i := 0; i < 10000; i++ {
+				key := fmt.Sprintf("key%d", r.Intn(50))
+				switch r.Intn(10) {
+				case 0:
+					if c.Delete(key) {
+						deleted++
+					}
+				case 1, 2, 3:
+					value := String(make([]byte, r.Intn(20)))
+					cached[key] = int64(len(key) + len(value))
+					c.SetWithExpire(key, value, time.Time{})
+				default:
+					c.Get(key)
+				}
+
+				var bytes int64
+				for _, size := range cached {
+					bytes += size
+				}
+				if c.Bytes() != bytes || c.Len() != len(cached) {
+					t.Fatalf("expect %d entries of %d bytes, but %d of %d got", len(cached), bytes, c.Len(), c.Bytes())
+				}
+				if c.Bytes() > maxBytes {
+					t.Fatalf("expect at most %d bytes, but %d got", maxBytes, c.Bytes())
+				}
+			}
+			if s := c.Stats(); s.Evictions == 0 || s.Evictions != evicted-deleted {
+				t.Fatalf("expect %d evictions, but %d got", evicted-deleted, s.Evictions)
+			}
+		})
+	}
+}
+
+func TestLFU(t *testing.T) {
+	c := NewLFUCache(int64(len("key1")*3+3), nil)
+	c.SetWithExpire("key1", String("1"), time.Time{})
+	c.SetWithExpire("key2", String("2"), time.Time{})
+	c.SetWithExpire("key3", String("3"), time.Time{})
+	c.Get("key1")
+	c.Get("key1")
+	c.Get("key3")
+
+	// key2 is used the least
+	c.SetWithExpire("key4", String("4"), time.Time{})
+	if _, ok := c.Get("key2"); ok {
+		t.Fatalf("expect key2 to be evicted")
+	}
+	for _, key := range []string{"key1", "key3", "key4"} {
+		if _, ok := c.Get(key); !ok {
+			t.Fatalf("expect %s to be cached", key)
+		}
+	}
+}
+
+// TestScanResistance checks that the scans of keys used once don't
+// evict the keys used twice in every round, as they do in LRU.
+func TestScanResistance(t *testing.T) {
+	for _, name := range []string{"ARC", "TwoQ", "TinyLFU"} {
+		t.Run(name, func(t *testing.T) {
+			c := policies[name](100*int64(len("key000")+1), nil)
+			hits := 0
+			for i := 0; i < 10; i++ {
+				hits = 0
+				for j := 0; j < 20; j++ {
+					key := fmt.Sprintf("hot%03d", j)
+					if _, ok := c.Get(key); ok {
+						hits++
+					} else {
+						c.SetWithExpire(key, String("v"), time.Time{})
+					}
+					c.Get(key)
+				}
+				for j := 0; j < 100; j++ {
+					c.SetWithExpire(fmt.Sprintf("key%03d", i*100+j), String("v"), time.Time{})
+				}
+			}
+			if hits < 15 {
+				t.Fatalf("expect most of the hot keys to survive the scans, but %d hits got", hits)
+			}
+		})
+	}
+}
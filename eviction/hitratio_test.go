@@ -0,0 +1,102 @@
+package eviction
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+const (
+	traceKeys    = 10000 // distinct keys of the Zipf distribution
+	traceLen     = 200000
+	traceEntries = 500 // entries the cache can hold in the replays
+)
+
+// zipfTrace returns the keys accessed with the Zipf distribution.
+func zipfTrace(seed int64) []string {
+	r := rand.New(rand.NewSource(seed))
+	z := rand.NewZipf(r, 1.01, 1, traceKeys-1)
+	trace := make([]string, traceLen)
+	for i := range trace {
+		trace[i] = fmt.Sprintf("key%05d", z.Uint64())
+	}
+	return trace
+}
+
+// scanTrace is the Zipf trace interleaved with the scans
+// of the keys that are only accessed once.
+func scanTrace(seed int64) []string {
+	var trace []string
+	for i, key := range zipfTrace(seed) {
+		if i%10000 == 0 {
+			for j := 0; j < 2*traceEntries; j++ {
+				trace = append(trace, fmt.Sprintf("scan%d-%d", i, j))
+			}
+		}
+		trace = append(trace, key)
+	}
+	return trace
+}
+
+// replay loads the missing keys like Group.Get and returns the hit ratio.
+func replay(newPolicy NewFunc, trace []string) float64 {
+	value := String("value")
+	c := newPolicy(traceEntries*int64(len("key00000")+value.Size()), nil)
+	for _, key := range trace {
+		if _, ok := c.Get(key); !ok {
+			c.SetWithExpire(key, value, time.Time{})
+		}
+	}
+	s := c.Stats()
+	return float64(s.Hits) / float64(s.Gets)
+}
+
+// TestHitRatio reports the hit ratios of the policies, run it with -v
+// to see them. W-TinyLFU is expected to do better than LRU.
+func TestHitRatio(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the replays in short mode")
+	}
+	traces := map[string][]string{
+		"zipf": zipfTrace(1),
+		"scan": scanTrace(1),
+	}
+	for _, trace := range []string{"zipf", "scan"} {
+		ratios := make(map[string]float64)
+		for _, name := range []string{"LRU", "LFU", "ARC", "TwoQ", "TinyLFU"} {
+			ratios[name] = replay(policies[name], traces[trace])
+			t.Logf("%-5s %-8s hit ratio %.4f", trace, name, ratios[name])
+		}
+		if ratios["TinyLFU"] <= ratios["LRU"] {
+			t.Fatalf("%s: expect TinyLFU to beat LRU, but %.4f <= %.4f got", trace, ratios["TinyLFU"], ratios["LRU"])
+		}
+	}
+}
+
+// BenchmarkHitRatio replays the traces with each policy,
+// the hit ratio is reported as the hit-ratio metric.
+func BenchmarkHitRatio(b *testing.B) {
+	traces := map[string][]string{
+		"zipf": zipfTrace(1),
+		"scan": scanTrace(1),
+	}
+	for _, trace := range []string{"zipf", "scan"} {
+		for _, name := range []string{"LRU", "LFU", "ARC", "TwoQ", "TinyLFU"} {
+			b.Run(trace+"/"+name, func(b *testing.B) {
+				value := String("value")
+				c := policies[name](traceEntries*int64(len("key00000")+value.Size()), nil)
+				keys := traces[trace]
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					key := keys[i%len(keys)]
+					if _, ok := c.Get(key); !ok {
+						c.SetWithExpire(key, value, time.Time{})
+					}
+				}
+				s := c.Stats()
+				b.ReportMetric(float64(s.Hits)/float64(s.Gets), "hit-ratio")
+			})
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package eviction
+
+import (
+	"container/list"
+	"time"
+)
+
+// LFUCache evicts the least frequently used entry, the least recently
+// used one is evicted among the entries with the same frequency.
+type LFUCache struct {
+	maxBytes  int64 // maximum bytes of memory available
+	curBytes  int64 // current bytes of memory in use
+	m         map[string]*list.Element
+	freqs     map[int]*entryList // map frequency to the entries used that many times
+	minFreq   int
+	onEvicted func(string, Value) // optional func, called when an entry is removed
+	stats     Stats
+}
+
+// NewLFUCache returns an LFUCache holding at most maxBytes, 0 means no limit.
+func NewLFUCache(maxBytes int64, onEvicted func(string, Value)) *LFUCache {
+	return &LFUCache{
+		maxBytes:  maxBytes,
+		m:         make(map[string]*list.Element),
+		freqs:     make(map[int]*entryList),
+		onEvicted: onEvicted,
+	}
+}
+
+// Get implements Policy interface function.
+func (c *LFUCache) Get(key string) (value Value, done bool) {
+	c.stats.Gets++
+	if elem, ok := c.m[key]; ok {
+		e := elem.Value.(*entry)
+		if e.expired(time.Now()) {
+			c.removeElement(elem)
+			c.stats.Expirations++
+			return
+		}
+		c.touch(elem)
+		value, done = e.value, true
+		c.stats.Hits++
+	}
+	return
+}
+
+// SetWithExpire implements Policy interface function.
+func (c *LFUCache) SetWithExpire(key string, value Value, expire time.Time) {
+	if elem, ok := c.m[key]; ok {
+		e := elem.Value.(*entry)
+		c.curBytes -= e.size
+		e.list.resize(elem, value, expire)
+		c.curBytes += e.size
+		c.touch(elem)
+	} else {
+		e := newEntry(key, value, expire)
+		// Make room before adding, otherwise the new entry
+		// is the least frequently used one to be evicted.
+		for c.maxBytes != 0 && c.maxBytes < c.curBytes+e.size && len(c.m) > 0 {
+			c.evict()
+		}
+		e.freq = 1
+		c.m[key] = c.list(1).pushBack(e)
+		c.curBytes += e.size
+		c.minFreq = 1
+	}
+
+	for c.maxBytes != 0 && c.maxBytes < c.curBytes {
+		c.evict()
+	}
+}
+
+// Delete implements Policy interface function.
+func (c *LFUCache) Delete(key string) bool {
+	if elem, ok := c.m[key]; ok {
+		c.removeElement(elem)
+		return true
+	}
+	return false
+}
+
+// RemoveExpired implements Policy interface function.
+func (c *LFUCache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for _, elem := range c.m {
+		if elem.Value.(*entry).expired(now) {
+			c.removeElement(elem)
+			n++
+		}
+	}
+	c.stats.Expirations += int64(n)
+	return n
+}
+
+// Bytes returns the bytes of memory in use.
+func (c *LFUCache) Bytes() int64 {
+	return c.curBytes
+}
+
+// Len returns how many entries are cached.
+func (c *LFUCache) Len() int {
+	return len(c.m)
+}
+
+// Stats returns the counters of the cache operations.
+func (c *LFUCache) Stats() Stats {
+	return c.stats
+}
+
+func (c *LFUCache) list(freq int) *entryList {
+	el, ok := c.freqs[freq]
+	if !ok {
+		el = newEntryList()
+		c.freqs[freq] = el
+	}
+	return el
+}
+
+// touch moves the entry to the list of the next frequency.
+func (c *LFUCache) touch(elem *list.Element) {
+	e := elem.Value.(*entry)
+	c.unlink(elem)
+	e.freq++
+	c.m[e.key] = c.list(e.freq).pushBack(e)
+}
+
+// unlink removes the element from its frequency list.
+func (c *LFUCache) unlink(elem *list.Element) {
+	e := elem.Value.(*entry)
+	el := e.list
+	el.remove(elem)
+	if el.len() == 0 {
+		delete(c.freqs, e.freq)
+		if c.minFreq == e.freq {
+			c.minFreq++
+		}
+	}
+}
+
+func (c *LFUCache) evict() {
+	el, ok := c.freqs[c.minFreq]
+	if !ok {
+		// minFreq is stale after the entries are deleted
+		c.minFreq = 0
+		for freq := range c.freqs {
+			if c.minFreq == 0 || freq < c.minFreq {
+				c.minFreq = freq
+			}
+		}
+		if el, ok = c.freqs[c.minFreq]; !ok {
+			return
+		}
+	}
+	c.removeElement(el.l.Front())
+	c.stats.Evictions++
+}
+
+func (c *LFUCache) removeElement(elem *list.Element) {
+	e := elem.Value.(*entry)
+	c.unlink(elem)
+	delete(c.m, e.key)
+	c.curBytes -= e.size
+	if c.onEvicted != nil {
+		c.onEvicted(e.key, e.value)
+	}
+}
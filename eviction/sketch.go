@@ -0,0 +1,89 @@
+package eviction
+
+import "hash/fnv"
+
+const (
+	sketchDepth      = 4
+	sketchMaxCounter = 15 // counters saturate like the 4-bit ones
+)
+
+// countMinSketch estimates the frequencies of the keys in a small
+// amount of memory, the estimation may be larger than the real one
+// because of the hash collisions, but never smaller.
+//
+// The counters are halved after sampleSize increments, so the
+// frequencies of the keys that are not used any more decay.
+type countMinSketch struct {
+	rows       [sketchDepth][]uint8
+	mask       uint64
+	additions  int
+	sampleSize int
+}
+
+func newCountMinSketch(width int) *countMinSketch {
+	w := 1
+	for w < width {
+		w <<= 1
+	}
+	s := &countMinSketch{
+		mask:       uint64(w - 1),
+		sampleSize: 10 * w,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, w)
+	}
+	return s
+}
+
+func (s *countMinSketch) width() int {
+	return len(s.rows[0])
+}
+
+// indexes returns the counter of the key in each row by double hashing.
+func (s *countMinSketch) indexes(key string) [sketchDepth]uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum, sum>>32|1
+	var idx [sketchDepth]uint64
+	for i := range idx {
+		idx[i] = (h1 + uint64(i)*h2) & s.mask
+	}
+	return idx
+}
+
+func (s *countMinSketch) increment(key string) {
+	added := false
+	for i, j := range s.indexes(key) {
+		if s.rows[i][j] < sketchMaxCounter {
+			s.rows[i][j]++
+			added = true
+		}
+	}
+	if added {
+		s.additions++
+		if s.additions >= s.sampleSize {
+			s.reset()
+		}
+	}
+}
+
+func (s *countMinSketch) estimate(key string) int {
+	freq := sketchMaxCounter
+	for i, j := range s.indexes(key) {
+		if c := int(s.rows[i][j]); c < freq {
+			freq = c
+		}
+	}
+	return freq
+}
+
+// reset halves all the counters to age the frequencies.
+func (s *countMinSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
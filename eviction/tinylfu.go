@@ -0,0 +1,202 @@
+package eviction
+
+import (
+	"container/list"
+	"time"
+)
+
+const (
+	tinyLFUWindowRatio    = 0.01 // ratio of maxBytes for the window
+	tinyLFUProtectedRatio = 0.8  // ratio of the main bytes for the protected segment
+	tinyLFUMinSketchWidth = 1024
+)
+
+// TinyLFUCache is the W-TinyLFU cache. The new entries are kept in a small
+// LRU window, when they leave the window they are only admitted into the
+// main segmented LRU if they are used more frequently than the entry to be
+// evicted from it, so a scan of keys used once doesn't flush the working set.
+//
+// The frequencies are estimated by a count-min sketch that ages periodically,
+// the main LRU is split into the probation segment and the protected
+// segment holding the entries used again in probation.
+type TinyLFUCache struct {
+	maxBytes     int64 // maximum bytes of memory available
+	windowBytes  int64 // maximum bytes of the window
+	protectBytes int64 // maximum bytes of the protected segment
+	window       *entryList
+	probation    *entryList
+	protected    *entryList
+	m            map[string]*list.Element
+	sketch       *countMinSketch
+	onEvicted    func(string, Value) // optional func, called when an entry is removed
+	stats        Stats
+}
+
+// NewTinyLFUCache returns a TinyLFUCache holding at most maxBytes, 0 means no limit.
+func NewTinyLFUCache(maxBytes int64, onEvicted func(string, Value)) *TinyLFUCache {
+	windowBytes := int64(float64(maxBytes) * tinyLFUWindowRatio)
+	return &TinyLFUCache{
+		maxBytes:     maxBytes,
+		windowBytes:  windowBytes,
+		protectBytes: int64(float64(maxBytes-windowBytes) * tinyLFUProtectedRatio),
+		window:       newEntryList(),
+		probation:    newEntryList(),
+		protected:    newEntryList(),
+		m:            make(map[string]*list.Element),
+		sketch:       newCountMinSketch(tinyLFUMinSketchWidth),
+		onEvicted:    onEvicted,
+	}
+}
+
+// Get implements Policy interface function.
+func (c *TinyLFUCache) Get(key string) (value Value, done bool) {
+	c.stats.Gets++
+	// The misses are counted too, so the keys loaded
+	// again and again are admitted eventually.
+	c.sketch.increment(key)
+	elem, ok := c.m[key]
+	if !ok {
+		return
+	}
+	e := elem.Value.(*entry)
+	if e.expired(time.Now()) {
+		c.removeElement(elem)
+		c.stats.Expirations++
+		return
+	}
+	c.touch(elem)
+	value, done = e.value, true
+	c.stats.Hits++
+	return
+}
+
+// SetWithExpire implements Policy interface function.
+func (c *TinyLFUCache) SetWithExpire(key string, value Value, expire time.Time) {
+	if elem, ok := c.m[key]; ok {
+		elem.Value.(*entry).list.resize(elem, value, expire)
+		c.touch(elem)
+	} else {
+		c.m[key] = c.window.pushBack(newEntry(key, value, expire))
+		if len(c.m) > c.sketch.width() {
+			c.sketch = newCountMinSketch(2 * len(c.m))
+		}
+	}
+	c.evictWindow()
+
+	// Only happens if an entry is larger than the window,
+	// or the size of an entry is increased.
+	for c.maxBytes != 0 && c.maxBytes < c.Bytes() && c.Len() > 0 {
+		victim := c.victim()
+		if victim == nil {
+			victim = c.window.l.Front()
+		}
+		c.evict(victim)
+	}
+}
+
+// Delete implements Policy interface function.
+func (c *TinyLFUCache) Delete(key string) bool {
+	if elem, ok := c.m[key]; ok {
+		c.removeElement(elem)
+		return true
+	}
+	return false
+}
+
+// RemoveExpired implements Policy interface function.
+func (c *TinyLFUCache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for _, elem := range c.m {
+		if elem.Value.(*entry).expired(now) {
+			c.removeElement(elem)
+			n++
+		}
+	}
+	c.stats.Expirations += int64(n)
+	return n
+}
+
+// Bytes returns the bytes of memory in use.
+func (c *TinyLFUCache) Bytes() int64 {
+	return c.window.bytes + c.probation.bytes + c.protected.bytes
+}
+
+// Len returns how many entries are cached.
+func (c *TinyLFUCache) Len() int {
+	return len(c.m)
+}
+
+// Stats returns the counters of the cache operations.
+func (c *TinyLFUCache) Stats() Stats {
+	return c.stats
+}
+
+// touch moves the used entry to the back of its segment,
+// an entry used in probation is promoted to protected.
+func (c *TinyLFUCache) touch(elem *list.Element) {
+	e := elem.Value.(*entry)
+	if e.list != c.probation {
+		e.list.l.MoveToBack(elem)
+		return
+	}
+	c.probation.remove(elem)
+	c.m[e.key] = c.protected.pushBack(e)
+	// Demote the oldest protected entries to probation
+	for c.protected.bytes > c.protectBytes && c.protected.len() > 1 {
+		e := c.protected.remove(c.protected.l.Front())
+		c.m[e.key] = c.probation.pushBack(e)
+	}
+}
+
+// evictWindow moves the entries out of the window to the probation segment,
+// when the cache is full, each of them competes with the victims of the main
+// segments, and the one used less frequently is evicted.
+func (c *TinyLFUCache) evictWindow() {
+	if c.maxBytes == 0 {
+		return
+	}
+	// The window keeps at least the newest entry
+	for c.window.bytes > c.windowBytes && c.window.len() > 1 {
+		candidate := c.window.remove(c.window.l.Front())
+		admitted := true
+		for c.maxBytes < c.Bytes()+candidate.size {
+			victim := c.victim()
+			if victim == nil || c.sketch.estimate(candidate.key) <= c.sketch.estimate(victim.Value.(*entry).key) {
+				admitted = false
+				break
+			}
+			c.evict(victim)
+		}
+		if !admitted {
+			delete(c.m, candidate.key)
+			if c.onEvicted != nil {
+				c.onEvicted(candidate.key, candidate.value)
+			}
+			c.stats.Evictions++
+			continue
+		}
+		c.m[candidate.key] = c.probation.pushBack(candidate)
+	}
+}
+
+// victim returns the entry to be evicted from the main segments first.
+func (c *TinyLFUCache) victim() *list.Element {
+	if elem := c.probation.l.Front(); elem != nil {
+		return elem
+	}
+	return c.protected.l.Front()
+}
+
+func (c *TinyLFUCache) evict(elem *list.Element) {
+	c.removeElement(elem)
+	c.stats.Evictions++
+}
+
+func (c *TinyLFUCache) removeElement(elem *list.Element) {
+	e := elem.Value.(*entry).list.remove(elem)
+	delete(c.m, e.key)
+	if c.onEvicted != nil {
+		c.onEvicted(e.key, e.value)
+	}
+}
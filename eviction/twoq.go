@@ -0,0 +1,172 @@
+package eviction
+
+import (
+	"container/list"
+	"time"
+)
+
+const (
+	twoQInRatio  = 0.25 // ratio of maxBytes for a1in
+	twoQOutRatio = 0.5  // ratio of maxBytes for the ghost entries of a1out
+)
+
+// TwoQCache is the 2Q cache, the new entries are kept in a FIFO queue
+// (a1in) and only promoted to the LRU list (am) if they are used again
+// shortly after being evicted from it, so a scan of keys used once
+// doesn't flush the frequently used ones.
+type TwoQCache struct {
+	maxBytes  int64 // maximum bytes of memory available
+	a1in      *entryList
+	a1out     *entryList // ghost entries evicted from a1in
+	am        *entryList
+	m         map[string]*list.Element // map key to the element in any of the lists
+	onEvicted func(string, Value)      // optional func, called when an entry is removed
+	stats     Stats
+}
+
+// NewTwoQCache returns a TwoQCache holding at most maxBytes, 0 means no limit.
+func NewTwoQCache(maxBytes int64, onEvicted func(string, Value)) *TwoQCache {
+	return &TwoQCache{
+		maxBytes:  maxBytes,
+		a1in:      newEntryList(),
+		a1out:     newEntryList(),
+		am:        newEntryList(),
+		m:         make(map[string]*list.Element),
+		onEvicted: onEvicted,
+	}
+}
+
+// Get implements Policy interface function.
+func (c *TwoQCache) Get(key string) (value Value, done bool) {
+	c.stats.Gets++
+	elem, ok := c.m[key]
+	if !ok || !c.resident(elem) {
+		return
+	}
+	e := elem.Value.(*entry)
+	if e.expired(time.Now()) {
+		c.removeElement(elem)
+		c.stats.Expirations++
+		return
+	}
+	// The entries of a1in stay in their place of the FIFO queue
+	if e.list == c.am {
+		c.am.l.MoveToBack(elem)
+	}
+	value, done = e.value, true
+	c.stats.Hits++
+	return
+}
+
+// SetWithExpire implements Policy interface function.
+func (c *TwoQCache) SetWithExpire(key string, value Value, expire time.Time) {
+	elem, ok := c.m[key]
+	if ok && c.resident(elem) {
+		e := elem.Value.(*entry)
+		e.list.resize(elem, value, expire)
+		if e.list == c.am {
+			c.am.l.MoveToBack(elem)
+		}
+		c.reclaim(0)
+		return
+	}
+
+	e := newEntry(key, value, expire)
+	target := c.a1in
+	if ok {
+		// The key is used again after it was evicted from a1in
+		c.a1out.remove(elem)
+		delete(c.m, key)
+		target = c.am
+	}
+	c.reclaim(e.size)
+	c.m[key] = target.pushBack(e)
+	c.reclaim(0)
+}
+
+// Delete implements Policy interface function.
+func (c *TwoQCache) Delete(key string) bool {
+	elem, ok := c.m[key]
+	if !ok {
+		return false
+	}
+	if !c.resident(elem) {
+		c.a1out.remove(elem)
+		delete(c.m, key)
+		return false
+	}
+	c.removeElement(elem)
+	return true
+}
+
+// RemoveExpired implements Policy interface function.
+func (c *TwoQCache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for _, el := range []*entryList{c.a1in, c.am} {
+		for elem := el.l.Front(); elem != nil; {
+			next := elem.Next()
+			if elem.Value.(*entry).expired(now) {
+				c.removeElement(elem)
+				n++
+			}
+			elem = next
+		}
+	}
+	c.stats.Expirations += int64(n)
+	return n
+}
+
+// Bytes returns the bytes of memory in use, the ghost entries are not counted.
+func (c *TwoQCache) Bytes() int64 {
+	return c.a1in.bytes + c.am.bytes
+}
+
+// Len returns how many entries are cached.
+func (c *TwoQCache) Len() int {
+	return c.a1in.len() + c.am.len()
+}
+
+// Stats returns the counters of the cache operations.
+func (c *TwoQCache) Stats() Stats {
+	return c.stats
+}
+
+func (c *TwoQCache) resident(elem *list.Element) bool {
+	return elem.Value.(*entry).list != c.a1out
+}
+
+// reclaim evicts the entries until there is room for size more bytes,
+// a1in is evicted first if it is larger than its share.
+func (c *TwoQCache) reclaim(size int64) {
+	for c.maxBytes != 0 && c.maxBytes < c.Bytes()+size && c.Len() > 0 {
+		if c.a1in.len() > 0 && (float64(c.a1in.bytes) > twoQInRatio*float64(c.maxBytes) || c.am.len() == 0) {
+			e := c.evict(c.a1in)
+			e.value = nil
+			c.m[e.key] = c.a1out.pushBack(e)
+			for float64(c.a1out.bytes) > twoQOutRatio*float64(c.maxBytes) {
+				delete(c.m, c.a1out.remove(c.a1out.l.Front()).key)
+			}
+		} else {
+			delete(c.m, c.evict(c.am).key)
+		}
+	}
+}
+
+// evict removes the oldest entry of the list.
+func (c *TwoQCache) evict(from *entryList) *entry {
+	e := from.remove(from.l.Front())
+	if c.onEvicted != nil {
+		c.onEvicted(e.key, e.value)
+	}
+	c.stats.Evictions++
+	return e
+}
+
+func (c *TwoQCache) removeElement(elem *list.Element) {
+	e := elem.Value.(*entry).list.remove(elem)
+	delete(c.m, e.key)
+	if c.onEvicted != nil {
+		c.onEvicted(e.key, e.value)
+	}
+}
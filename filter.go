@@ -0,0 +1,76 @@
+package mayflycache
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hey-kong/mayflycache/bloom"
+)
+
+// A KeyLister is a Getter that also lists all the keys that exist,
+// it is used by Group.RebuildFilter to build the filter of the keys.
+type KeyLister interface {
+	Getter
+	Keys(ctx context.Context) ([]string, error)
+}
+
+// WithFilter guards the Group with the Bloom filter of the keys known to
+// exist, the keys that are definitely not in the filter are reported as
+// ErrNotFound by Get without loading them from the Getter or the peers.
+// Set only adds the key to the filters of the current node, the owner and
+// the replicas, the other nodes keep rejecting it until the filter is
+// pushed to them again by SetFilter.
+func WithFilter(f *bloom.Filter) GroupOption {
+	return func(g *Group) {
+		g.SetFilter(f)
+	}
+}
+
+// Filter returns the Bloom filter of the Group, or nil if it is not guarded.
+func (g *Group) Filter() *bloom.Filter {
+	f, _ := g.filter.Load().(*bloom.Filter)
+	return f
+}
+
+// SetFilter replaces the Bloom filter of the Group, e.g. by the one
+// decoded from another node, nil removes the guard.
+func (g *Group) SetFilter(f *bloom.Filter) {
+	g.filter.Store(f)
+}
+
+// RebuildFilter builds a new Bloom filter with the false positive rate
+// fpRate from the keys listed by the Getter, which must implement
+// KeyLister, and replaces the filter of the Group with it.
+func (g *Group) RebuildFilter(ctx context.Context, fpRate float64) (*bloom.Filter, error) {
+	kl, ok := g.getter.(KeyLister)
+	if !ok {
+		return nil, fmt.Errorf("the Getter of group %s doesn't list the keys", g.name)
+	}
+	keys, err := kl.Keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f := bloom.New(len(keys), fpRate)
+	for _, key := range keys {
+		f.Add(key)
+	}
+	g.SetFilter(f)
+	return f, nil
+}
+
+// rejectByFilter reports whether the key is definitely absent.
+func (g *Group) rejectByFilter(key string) bool {
+	if f := g.Filter(); f != nil && !f.Has(key) {
+		g.stats.filterRejects.Add(1)
+		return true
+	}
+	return false
+}
+
+// addToFilter adds the key set on the current node to the filter,
+// so it is not rejected.
+func (g *Group) addToFilter(key string) {
+	if f := g.Filter(); f != nil {
+		f.Add(key)
+	}
+}
@@ -0,0 +1,95 @@
+package mayflycache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hey-kong/mayflycache"
+	"github.com/hey-kong/mayflycache/bloom"
+)
+
+// listGetter serves and lists the keys of info.
+type listGetter struct {
+	loads int
+}
+
+func (lg *listGetter) Get(ctx context.Context, key string) ([]byte, error) {
+	lg.loads++
+	if v, ok := info[key]; ok {
+		return []byte(v), nil
+	}
+	return nil, mayflycache.ErrNotFound
+}
+
+func (lg *listGetter) Keys(ctx context.Context) ([]string, error) {
+	var keys []string
+	for key := range info {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func TestFilter(t *testing.T) {
+	f := bloom.New(100, 0.001)
+	f.Add("Name")
+	lg := &listGetter{}
+	g := mayflycache.NewGroup("filter", 2<<10, lg, mayflycache.WithFilter(f))
+	peer := &fakePeer{}
+	g.RegisterPeers(peer)
+
+	if _, err := g.Get(context.Background(), "Unknown"); !errors.Is(err, mayflycache.ErrNotFound) {
+		t.Fatalf("expect ErrNotFound, but %v got", err)
+	}
+	if v, err := g.Get(context.Background(), "Name"); err != nil || v.String() != "peer-Name" {
+		t.Fatalf("expect peer-Name, but %v (%v) got", v, err)
+	}
+	if s := g.Stats(); lg.loads != 0 || peer.calls != 1 || s.FilterRejects != 1 {
+		t.Fatalf("expect only Name to reach the peer, but %d loads, %d calls and %+v got", lg.loads, peer.calls, s)
+	}
+
+	// The key set on the current node is added to the filter
+	g.Set(context.Background(), "Age", []byte("21"), 0)
+	if !g.Filter().Has("Age") {
+		t.Fatalf("expect Age to be added to the filter")
+	}
+
+	// Share the filter with another group
+	b, _ := g.Filter().MarshalBinary()
+	shared := &bloom.Filter{}
+	if err := shared.UnmarshalBinary(b); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	other := mayflycache.NewGroup("filter-other", 2<<10, lg)
+	other.SetFilter(shared)
+	if _, err := other.Get(context.Background(), "Hobby"); !errors.Is(err, mayflycache.ErrNotFound) || lg.loads != 0 {
+		t.Fatalf("expect Hobby to be rejected by the shared filter, but %v got", err)
+	}
+}
+
+func TestRebuildFilter(t *testing.T) {
+	lg := &listGetter{}
+	g := mayflycache.NewGroup("filter-rebuild", 2<<10, lg)
+	if _, err := g.RebuildFilter(context.Background(), 0.001); err != nil {
+		t.Fatalf("rebuild failed: %v", err)
+	}
+	if _, err := g.Get(context.Background(), "Unknown"); !errors.Is(err, mayflycache.ErrNotFound) || lg.loads != 0 {
+		t.Fatalf("expect Unknown to be rejected, but %v got", err)
+	}
+	if v, err := g.Get(context.Background(), "Hobby"); err != nil || v.String() != info["Hobby"] {
+		t.Fatalf("expect %s, but %v (%v) got", info["Hobby"], v, err)
+	}
+
+	g.SetFilter(nil)
+	g.Get(context.Background(), "Unknown")
+	if lg.loads != 2 {
+		t.Fatalf("expect Unknown to be loaded without the filter, but %d loads got", lg.loads)
+	}
+
+	plain := mayflycache.NewGroup("filter-plain", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) { return nil, nil },
+	))
+	if _, err := plain.RebuildFilter(context.Background(), 0.01); err == nil {
+		t.Fatalf("expect an error if the Getter doesn't list the keys")
+	}
+}
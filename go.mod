@@ -2,4 +2,7 @@ module github.com/hey-kong/mayflycache
 
 go 1.15
 
-require google.golang.org/protobuf v1.26.0
+require (
+	google.golang.org/grpc v1.43.0
+	google.golang.org/protobuf v1.26.0
+)
@@ -0,0 +1,29 @@
+package mayflycache
+
+import "github.com/hey-kong/mayflycache/swim"
+
+// Gossip starts a SWIM node named after the current node, it keeps the
+// peers of the pool in sync with the alive members of the cluster, so
+// the hash ring is updated when the nodes join, leave or fail.
+// Call Join on the returned node to contact the seeds.
+func (hp *HTTPPool) Gossip(config swim.Config) *swim.Node {
+	config.Name = hp.self
+	onJoin, onLeave := config.OnJoin, config.OnLeave
+	config.OnJoin = func(m swim.Member) {
+		hp.Log("Peer %s joined", m.Name)
+		hp.AddPeers(m.Name)
+		if onJoin != nil {
+			onJoin(m)
+		}
+	}
+	config.OnLeave = func(m swim.Member) {
+		hp.Log("Peer %s left", m.Name)
+		hp.RemovePeers(m.Name)
+		if onLeave != nil {
+			onLeave(m)
+		}
+	}
+
+	hp.AddPeers(hp.self)
+	return swim.New(config)
+}
@@ -0,0 +1,56 @@
+package mayflycache_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hey-kong/mayflycache"
+	"github.com/hey-kong/mayflycache/swim"
+)
+
+func TestGossip(t *testing.T) {
+	network := swim.NewMemNetwork()
+	var pools []*mayflycache.HTTPPool
+	var nodes []*swim.Node
+	for i := 0; i < 3; i++ {
+		hp := mayflycache.NewHTTPPool(fmt.Sprintf("http://node%d", i))
+		node := hp.Gossip(swim.Config{
+			Transport:        network.NewTransport(fmt.Sprintf("addr%d", i)),
+			ProbeInterval:    20 * time.Millisecond,
+			SuspicionTimeout: 100 * time.Millisecond,
+			SyncInterval:     50 * time.Millisecond,
+		})
+		defer node.Shutdown()
+		pools = append(pools, hp)
+		nodes = append(nodes, node)
+	}
+	for _, node := range nodes[1:] {
+		if err := node.Join("addr0"); err != nil {
+			t.Fatalf("join failed: %v", err)
+		}
+	}
+
+	waitPeers := func(pools []*mayflycache.HTTPPool, want ...string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for _, hp := range pools {
+			for !reflect.DeepEqual(hp.Peers(), want) {
+				if time.Now().After(deadline) {
+					t.Fatalf("expect peers %v, but %v got", want, hp.Peers())
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+	waitPeers(pools, "http://node0", "http://node1", "http://node2")
+
+	// The ring routes around the partitioned node
+	network.Partition([]string{"addr2"}, []string{"addr0", "addr1"})
+	waitPeers(pools[:2], "http://node0", "http://node1")
+	waitPeers(pools[2:], "http://node2")
+
+	network.Heal()
+	waitPeers(pools, "http://node0", "http://node1", "http://node2")
+}
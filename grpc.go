@@ -0,0 +1,304 @@
+package mayflycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hey-kong/mayflycache/consistenthash"
+	pb "github.com/hey-kong/mayflycache/mayflycachepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// A GRPCServer implements the MayflyCache gRPC service on the groups,
+// it is registered by pb.RegisterMayflyCacheServer.
+type GRPCServer struct {
+	pb.UnimplementedMayflyCacheServer
+}
+
+// NewGRPCServer returns a GRPCServer serving all the groups.
+func NewGRPCServer() *GRPCServer {
+	return &GRPCServer{}
+}
+
+// Get implements the Get RPC.
+func (s *GRPCServer) Get(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	group, err := lookupGroup(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	value, err := group.Get(ctx, in.GetKey())
+	if errors.Is(err, ErrNotFound) {
+		return &pb.Response{NotFound: true}, nil
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	res := &pb.Response{Value: value.ByteSlice()}
+	if e := value.Expire(); !e.IsZero() {
+		res.Expire = e.UnixNano()
+	}
+	return res, nil
+}
+
+// GetMany implements the GetMany RPC, the errors of the keys are
+// returned in the results instead of the status.
+func (s *GRPCServer) GetMany(ctx context.Context, in *pb.BatchRequest) (*pb.BatchResponse, error) {
+	group, err := lookupGroup(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	values, errs := group.GetMany(ctx, in.GetKeys())
+	return batchResponse(values, errs), nil
+}
+
+// Set implements the Set RPC, it stores the value on the current node only.
+func (s *GRPCServer) Set(ctx context.Context, in *pb.SetRequest) (*pb.SetResponse, error) {
+	group, err := lookupGroup(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	var expire time.Time
+	if in.Expire != 0 {
+		expire = time.Unix(0, in.Expire)
+	}
+	group.setLocally(in.GetKey(), NewChunkWithExpire(in.GetValue(), expire))
+	return &pb.SetResponse{}, nil
+}
+
+// Delete implements the Delete RPC, it invalidates the key on the current node only.
+func (s *GRPCServer) Delete(ctx context.Context, in *pb.Request) (*pb.DeleteResponse, error) {
+	group, err := lookupGroup(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	group.removeLocally(in.GetKey())
+	return &pb.DeleteResponse{}, nil
+}
+
+func lookupGroup(name string) (*Group, error) {
+	group := GetGroup(name)
+	if group == nil {
+		return nil, status.Error(codes.NotFound, "no such group: "+name)
+	}
+	return group, nil
+}
+
+// toStatus maps the load errors to the gRPC status codes.
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+// A GRPCPool implements PeerPicker with the peers talking gRPC, it is an
+// alternative to HTTPPool. The connection to each peer is reused by all
+// the requests to it.
+type GRPCPool struct {
+	self        string // address of the current node, e.g. "localhost:8001"
+	dialOpts    []grpc.DialOption
+	mu          sync.Mutex
+	peers       *consistenthash.Map
+	grpcGetters map[string]*grpcGetter // map node address to grpcGetter
+}
+
+// NewGRPCPool initializes a gRPC pool of peers, the connections are
+// dialed with opts, or without transport security if opts is empty.
+func NewGRPCPool(self string, opts ...grpc.DialOption) *GRPCPool {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	return &GRPCPool{
+		self:     self,
+		dialOpts: opts,
+	}
+}
+
+// Log prints the log with the server name.
+func (gp *GRPCPool) Log(format string, v ...interface{}) {
+	log.Printf("[Server %s]%s", gp.self, fmt.Sprintf(format, v...))
+}
+
+// Set replaces the peers of the pool, the connections to
+// the peers that are not in the pool any more are closed.
+func (gp *GRPCPool) Set(peers ...string) error {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	old := gp.grpcGetters
+	gp.peers = consistenthash.New(defaultReplicas, nil)
+	gp.grpcGetters = make(map[string]*grpcGetter, len(peers))
+	for _, peer := range peers {
+		if g, ok := old[peer]; ok {
+			gp.peers.Set(peer)
+			gp.grpcGetters[peer] = g
+			delete(old, peer)
+		}
+	}
+	for peer, g := range old {
+		g.conn.Close()
+		forgetPeer(peer)
+	}
+	return gp.addPeers(peers)
+}
+
+// AddPeers adds the peers to the pool without rebuilding the hash ring.
+func (gp *GRPCPool) AddPeers(peers ...string) error {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	if gp.peers == nil {
+		gp.peers = consistenthash.New(defaultReplicas, nil)
+		gp.grpcGetters = make(map[string]*grpcGetter, len(peers))
+	}
+	return gp.addPeers(peers)
+}
+
+// addPeers must be called with gp.mu held.
+func (gp *GRPCPool) addPeers(peers []string) error {
+	for _, peer := range peers {
+		if _, ok := gp.grpcGetters[peer]; ok {
+			continue
+		}
+		// Dial doesn't block, the connection is established in background
+		conn, err := grpc.Dial(peer, gp.dialOpts...)
+		if err != nil {
+			return err
+		}
+		gp.peers.Set(peer)
+		gp.grpcGetters[peer] = &grpcGetter{
+			addr:   peer,
+			conn:   conn,
+			client: pb.NewMayflyCacheClient(conn),
+		}
+	}
+	return nil
+}
+
+// RemovePeers removes the peers from the pool and closes their connections.
+func (gp *GRPCPool) RemovePeers(peers ...string) {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	for _, peer := range peers {
+		if g, ok := gp.grpcGetters[peer]; ok {
+			gp.peers.Remove(peer)
+			delete(gp.grpcGetters, peer)
+			g.conn.Close()
+			forgetPeer(peer)
+		}
+	}
+}
+
+// Peers returns the sorted peers in the pool, including the current node.
+func (gp *GRPCPool) Peers() []string {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	peers := make([]string, 0, len(gp.grpcGetters))
+	for peer := range gp.grpcGetters {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// Close closes the connections to all the peers.
+func (gp *GRPCPool) Close() error {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	for peer, g := range gp.grpcGetters {
+		g.conn.Close()
+		gp.peers.Remove(peer)
+		delete(gp.grpcGetters, peer)
+		forgetPeer(peer)
+	}
+	return nil
+}
+
+// PickPeer implements PeerPicker interface for GRPCPool to return the grpcGetter according to the key.
+func (gp *GRPCPool) PickPeer(key string) (PeerGetter, bool) {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	if gp.peers == nil {
+		return nil, false
+	}
+	if peer := gp.peers.Get(key); peer != "" && peer != gp.self {
+		gp.Log("Pick peer %s", peer)
+		return gp.grpcGetters[peer], true
+	}
+	return nil, false
+}
+
+// GetAll implements PeerPicker interface for GRPCPool to return the grpcGetters of other peers.
+func (gp *GRPCPool) GetAll() []PeerGetter {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	var peers []PeerGetter
+	for peer, getter := range gp.grpcGetters {
+		if peer != gp.self {
+			peers = append(peers, getter)
+		}
+	}
+	return peers
+}
+
+// grpcGetter is an implementation of PeerGetter on gRPC.
+type grpcGetter struct {
+	addr   string
+	conn   *grpc.ClientConn
+	client pb.MayflyCacheClient
+}
+
+// String returns the peer name used in the metrics.
+func (g *grpcGetter) String() string {
+	return g.addr
+}
+
+// Get calls the Get RPC, the deadline of ctx is sent to the peer.
+func (g *grpcGetter) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	res, err := g.client.Get(ctx, in)
+	if err != nil {
+		return err
+	}
+	out.Value, out.Expire, out.NotFound = res.Value, res.Expire, res.NotFound
+	return nil
+}
+
+// GetMany calls the GetMany RPC.
+func (g *grpcGetter) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	res, err := g.client.GetMany(ctx, in)
+	if err != nil {
+		return err
+	}
+	out.Results = res.Results
+	return nil
+}
+
+// Set calls the Set RPC.
+func (g *grpcGetter) Set(ctx context.Context, in *pb.SetRequest) error {
+	_, err := g.client.Set(ctx, in)
+	return err
+}
+
+// Delete calls the Delete RPC.
+func (g *grpcGetter) Delete(ctx context.Context, in *pb.Request) error {
+	_, err := g.client.Delete(ctx, in)
+	return err
+}
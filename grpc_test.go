@@ -0,0 +1,154 @@
+package mayflycache_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hey-kong/mayflycache"
+	pb "github.com/hey-kong/mayflycache/mayflycachepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startGRPC serves the groups over an in-memory listener and returns a
+// pool whose only peer is the server.
+func startGRPC(t *testing.T) (*mayflycache.GRPCPool, func()) {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	pb.RegisterMayflyCacheServer(s, mayflycache.NewGRPCServer())
+	go s.Serve(lis)
+
+	pool := mayflycache.NewGRPCPool("client",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err := pool.Set("bufnet"); err != nil {
+		t.Fatalf("set peers failed: %v", err)
+	}
+	return pool, func() {
+		pool.Close()
+		s.Stop()
+	}
+}
+
+func TestGRPCPool(t *testing.T) {
+	g := mayflycache.NewGroup("grpc-remote", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			if key == "Unknown" {
+				return nil, errors.New("Unknown not exists")
+			}
+			return []byte("remote-" + key), nil
+		},
+	), mayflycache.WithTTL(time.Hour))
+	pool, stop := startGRPC(t)
+	defer stop()
+
+	peer, ok := pool.PickPeer("Name")
+	if !ok {
+		t.Fatalf("expect to pick the peer bufnet")
+	}
+	res := &pb.Response{}
+	if err := peer.Get(context.Background(), &pb.Request{Group: "grpc-remote", Key: "Name"}, res); err != nil {
+		t.Fatalf("get from peer failed: %v", err)
+	}
+	if string(res.GetValue()) != "remote-Name" || res.GetExpire() == 0 {
+		t.Fatalf("expect remote-Name with expiration, but %s (%d) got", res.GetValue(), res.GetExpire())
+	}
+
+	err := peer.Get(context.Background(), &pb.Request{Group: "grpc-remote", Key: "Unknown"}, res)
+	if status.Code(err) != codes.Unknown {
+		t.Fatalf("expect Unknown for a load error, but %v got", err)
+	}
+	err = peer.Get(context.Background(), &pb.Request{Group: "unknown", Key: "Name"}, res)
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expect NotFound for unknown group, but %v got", err)
+	}
+
+	batch := &pb.BatchResponse{}
+	if err := peer.GetMany(context.Background(), &pb.BatchRequest{Group: "grpc-remote", Keys: []string{"Name", "Unknown"}}, batch); err != nil {
+		t.Fatalf("get many from peer failed: %v", err)
+	}
+	if len(batch.Results) != 2 || string(batch.Results[0].GetValue()) != "remote-Name" || batch.Results[1].GetError() == "" {
+		t.Fatalf("expect remote-Name and the error of Unknown, but %v got", batch.Results)
+	}
+
+	if err := peer.Set(context.Background(), &pb.SetRequest{Group: "grpc-remote", Key: "Age", Value: []byte("21")}); err != nil {
+		t.Fatalf("set to peer failed: %v", err)
+	}
+	if v, err := g.Get(context.Background(), "Age"); err != nil || v.String() != "21" {
+		t.Fatalf("expect 21 stored by the peer, but %v got", v)
+	}
+	if err := peer.Delete(context.Background(), &pb.Request{Group: "grpc-remote", Key: "Age"}); err != nil {
+		t.Fatalf("delete from peer failed: %v", err)
+	}
+	if v, _ := g.Get(context.Background(), "Age"); v.String() != "remote-Age" {
+		t.Fatalf("expect Age to be loaded again after delete, but %v got", v)
+	}
+}
+
+func TestGRPCPoolNotFound(t *testing.T) {
+	mayflycache.NewGroup("grpc-missing", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			if key == "Missing" {
+				return nil, mayflycache.ErrNotFound
+			}
+			return []byte("remote-" + key), nil
+		},
+	))
+	pool, stop := startGRPC(t)
+	defer stop()
+
+	peer, _ := pool.PickPeer("Name")
+	res := &pb.Response{}
+	if err := peer.Get(context.Background(), &pb.Request{Group: "grpc-missing", Key: "Name"}, res); err != nil || res.GetNotFound() {
+		t.Fatalf("expect remote-Name, but %v (%v) got", res, err)
+	}
+	// The response is reused, so the value of Name must not be left over
+	err := peer.Get(context.Background(), &pb.Request{Group: "grpc-missing", Key: "Missing"}, res)
+	if err != nil || !res.GetNotFound() || len(res.GetValue()) != 0 {
+		t.Fatalf("expect Missing to be not found, but %v (%v) got", res, err)
+	}
+}
+
+func TestGRPCPoolDeadline(t *testing.T) {
+	mayflycache.NewGroup("grpc-slow", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	))
+	pool, stop := startGRPC(t)
+	defer stop()
+
+	peer, _ := pool.PickPeer("Name")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := peer.Get(ctx, &pb.Request{Group: "grpc-slow", Key: "Name"}, &pb.Response{})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expect DeadlineExceeded, but %v got", err)
+	}
+}
+
+func TestGRPCPoolPeers(t *testing.T) {
+	pool := mayflycache.NewGRPCPool("localhost:8001")
+	defer pool.Close()
+	pool.Set("localhost:8001", "localhost:8002")
+	pool.AddPeers("localhost:8003")
+	pool.RemovePeers("localhost:8002")
+
+	peers := pool.Peers()
+	if len(peers) != 2 || peers[0] != "localhost:8001" || peers[1] != "localhost:8003" {
+		t.Fatalf("expect [localhost:8001 localhost:8003], but %v got", peers)
+	}
+	if n := len(pool.GetAll()); n != 1 {
+		t.Fatalf("expect 1 other peer, but %d got", n)
+	}
+}
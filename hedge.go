@@ -0,0 +1,181 @@
+package mayflycache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// A HedgePicker is a PeerPicker that also picks the peer to send a
+// duplicate request of the key to when the owner is slow, usually the
+// next one on the hash ring; ok is false if the key should be loaded
+// locally instead.
+type HedgePicker interface {
+	PickHedge(key string, owner PeerGetter) (peer PeerGetter, ok bool)
+}
+
+// WithHedging sends a duplicate request of the key after delay if the
+// owner hasn't answered yet, to the peer picked by the HedgePicker, or
+// to the Getter if the PeerPicker doesn't implement it, and takes
+// whichever answers first; the other one is canceled. The hedge peer
+// loads the key by its Getter rather than asking the slow owner, and
+// both the peer and the current node keep the value in the hotCache if
+// the Groups are created WithHotCache.
+func WithHedging(delay time.Duration) GroupOption {
+	return func(g *Group) {
+		g.hedgeDelay = delay
+	}
+}
+
+// WithRetries retries a failed Get from a peer at most n times, waiting
+// for a jittered backoff which doubles after each attempt. The keys not
+// found and the requests abandoned by the caller are not retried.
+func WithRetries(n int, backoff time.Duration) GroupOption {
+	return func(g *Group) {
+		g.retries = n
+		g.backoff = backoff
+	}
+}
+
+// fetchResult is the result of a request of the key, hedge means it is
+// from the duplicate request, and local means it is loaded by the Getter.
+type fetchResult struct {
+	value Chunk
+	hedge bool
+	local bool
+	err   error
+}
+
+// fetch gets the value of the key from the peer, hedged by the options,
+// detour means the peer is not the owner of the key, and local reports
+// whether the result is from the Getter.
+func (g *Group) fetch(ctx context.Context, peer PeerGetter, key string, detour bool) (value Chunk, local bool, err error) {
+	peerCtx := ctx
+	if detour {
+		peerCtx = withDetour(ctx)
+	}
+	if g.hedgeDelay <= 0 {
+		value, err = g.getFromPeerRetried(peerCtx, peer, key)
+		return value, false, err
+	}
+
+	hedgeCtx, cancel := context.WithCancel(peerCtx)
+	defer cancel()
+	results := make(chan fetchResult, 2)
+	go func() {
+		value, err := g.getFromPeerRetried(hedgeCtx, peer, key)
+		results <- fetchResult{value: value, err: err}
+	}()
+
+	timer := time.NewTimer(g.hedgeDelay)
+	defer timer.Stop()
+	pending, hedged := 1, false
+	var failed fetchResult
+	for {
+		select {
+		case <-timer.C:
+			hedged = true
+			pending++
+			g.stats.hedges.Add(1)
+			g.hedge(ctx, hedgeCtx, peer, key, results)
+		case r := <-results:
+			pending--
+			// A missing key is an answer as well as a value
+			if r.err == nil || errors.Is(r.err, ErrNotFound) {
+				if r.hedge && r.err == nil {
+					g.stats.hedgeWins.Add(1)
+					// The hedge peer keeps it in the hotCache only
+					if !r.local && g.hotCache != nil {
+						g.hotCache.Set(key, r.value)
+					}
+				}
+				return r.value, r.local, r.err
+			}
+			// The error of the Getter is preferred, so it isn't loaded again
+			if !failed.local {
+				failed = r
+			}
+			// A failed owner is handled by the caller unless it has been hedged
+			if !hedged || pending == 0 {
+				return failed.value, failed.local, failed.err
+			}
+		case <-ctx.Done():
+			return Chunk{}, false, ctx.Err()
+		}
+	}
+}
+
+// hedge sends the duplicate request of the key, whose result is sent to results.
+func (g *Group) hedge(ctx, hedgeCtx context.Context, owner PeerGetter, key string, results chan<- fetchResult) {
+	if hp, ok := g.peers.(HedgePicker); ok {
+		if peer, ok := hp.PickHedge(key, owner); ok {
+			go func() {
+				value, err := g.getFromPeer(withDetour(hedgeCtx), peer, key)
+				results <- fetchResult{value: value, hedge: true, err: err}
+			}()
+			return
+		}
+	}
+	// The local load goes on with ctx even if the owner answers first,
+	// so the value is cached anyway.
+	go func() {
+		value, err := g.getLocallyHot(ctx, key)
+		results <- fetchResult{value: value, hedge: true, local: true, err: err}
+	}()
+}
+
+// detourKey is the key of the context value marking the detours.
+type detourKey struct{}
+
+// withDetour marks the requests to the peers sent with ctx as detours
+// around the owner of the key, the peer loads the key by its Getter
+// rather than sending it to the owner.
+func withDetour(ctx context.Context) context.Context {
+	return context.WithValue(ctx, detourKey{}, true)
+}
+
+// isDetour reports whether ctx is marked by withDetour.
+func isDetour(ctx context.Context) bool {
+	detour, _ := ctx.Value(detourKey{}).(bool)
+	return detour
+}
+
+// getFromPeerRetried gets the value of the key from the peer, retried by the options.
+func (g *Group) getFromPeerRetried(ctx context.Context, peer PeerGetter, key string) (Chunk, error) {
+	backoff := g.backoff
+	for i := 0; ; i++ {
+		value, err := g.getFromPeer(ctx, peer, key)
+		if err == nil || i >= g.retries || errors.Is(err, ErrNotFound) || ctx.Err() != nil {
+			return value, err
+		}
+		log.Println("Retry getting from peer:", err)
+		g.stats.retries.Add(1)
+		if !sleep(ctx, jitter(backoff)) {
+			return value, err
+		}
+		backoff *= 2
+	}
+}
+
+// jitter returns a random duration in [d/2, d), so the retries
+// of many keys don't hit the peer at once.
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// sleep waits for d, it returns false if ctx is done before.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,193 @@
+package mayflycache_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hey-kong/mayflycache"
+	pb "github.com/hey-kong/mayflycache/mayflycachepb"
+)
+
+// slowPeer answers after delay unless the request is canceled,
+// and it fails the first failures requests.
+type slowPeer struct {
+	fakePeer
+	delay    time.Duration
+	failures int32
+	canceled int32
+}
+
+func (p *slowPeer) PickPeer(key string) (mayflycache.PeerGetter, bool) {
+	return p, true
+}
+
+func (p *slowPeer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	p.fakePeer.Get(ctx, in, out)
+	if atomic.AddInt32(&p.failures, -1) >= 0 {
+		return errors.New("peer is overloaded")
+	}
+	select {
+	case <-time.After(p.delay):
+		return nil
+	case <-ctx.Done():
+		atomic.AddInt32(&p.canceled, 1)
+		return ctx.Err()
+	}
+}
+
+// hedgePicker picks owner for every key and hedges to hedge.
+type hedgePicker struct {
+	*slowPeer
+	hedge *fakePeer
+}
+
+func (p *hedgePicker) PickHedge(key string, owner mayflycache.PeerGetter) (mayflycache.PeerGetter, bool) {
+	return p.hedge, true
+}
+
+func localGetter() mayflycache.Getter {
+	return mayflycache.GetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		return []byte("local-" + key), nil
+	})
+}
+
+func TestHedgingLocal(t *testing.T) {
+	g := mayflycache.NewGroup("hedge-local", 2<<10, localGetter(), mayflycache.WithHedging(20*time.Millisecond))
+	peer := &slowPeer{delay: time.Second}
+	g.RegisterPeers(peer)
+
+	start := time.Now()
+	if v, err := g.Get(context.Background(), "Name"); err != nil || v.String() != "local-Name" {
+		t.Fatalf("expect local-Name, but %v (%v) got", v, err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("expect the hedge to answer before the slow owner, but %v got", d)
+	}
+	if !waitFor(func() bool { return atomic.LoadInt32(&peer.canceled) == 1 }) {
+		t.Fatalf("expect the request to the slow owner to be canceled")
+	}
+	if s := g.Stats(); s.Hedges != 1 || s.HedgeWins != 1 || s.LocalLoads != 1 || s.PeerLoads != 0 {
+		t.Fatalf("expect the hedge to win, but %+v got", s)
+	}
+	// The current node doesn't own the key, and there is no hotCache
+	if s := g.Stats(); s.MainCache.Items != 0 {
+		t.Fatalf("expect the value not to be cached, but %+v got", s.MainCache)
+	}
+
+	// The owner answering within the delay is not hedged
+	fast := mayflycache.NewGroup("hedge-fast", 2<<10, localGetter(), mayflycache.WithHedging(time.Second))
+	fast.RegisterPeers(&slowPeer{})
+	if v, err := fast.Get(context.Background(), "Name"); err != nil || v.String() != "peer-Name" {
+		t.Fatalf("expect peer-Name, but %v (%v) got", v, err)
+	}
+	if s := fast.Stats(); s.Hedges != 0 || s.PeerLoads != 1 {
+		t.Fatalf("expect no hedge, but %+v got", s)
+	}
+}
+
+func TestHedgingPeer(t *testing.T) {
+	g := mayflycache.NewGroup("hedge-peer", 2<<10, localGetter(), mayflycache.WithHedging(20*time.Millisecond))
+	picker := &hedgePicker{slowPeer: &slowPeer{delay: time.Second}, hedge: &fakePeer{}}
+	g.RegisterPeers(picker)
+
+	if v, err := g.Get(context.Background(), "Name"); err != nil || v.String() != "peer-Name" {
+		t.Fatalf("expect peer-Name, but %v (%v) got", v, err)
+	}
+	if s := g.Stats(); picker.hedge.calls != 1 || s.HedgeWins != 1 || s.PeerLoads != 1 || s.LocalLoads != 0 {
+		t.Fatalf("expect the hedge peer to win, but %d calls and %+v got", picker.hedge.calls, s)
+	}
+
+	// The hedged value is kept even if the hotCache ratio is 0
+	hot := mayflycache.NewGroup("hedge-peer-hot", 2<<10, localGetter(),
+		mayflycache.WithHedging(20*time.Millisecond), mayflycache.WithHotCache(2<<10, 0))
+	picker = &hedgePicker{slowPeer: &slowPeer{delay: time.Second}, hedge: &fakePeer{}}
+	hot.RegisterPeers(picker)
+	hot.Get(context.Background(), "Name")
+	if v, err := hot.Get(context.Background(), "Name"); err != nil || v.String() != "peer-Name" || picker.hedge.calls != 1 {
+		t.Fatalf("expect peer-Name from the hotCache, but %v (%v) and %d calls got", v, err, picker.hedge.calls)
+	}
+}
+
+func TestHTTPPoolHedging(t *testing.T) {
+	owner := slowServer(-1, nil)
+	defer owner.Close()
+	next := httptest.NewServer(mayflycache.NewHTTPPool("http://next"))
+	defer next.Close()
+
+	// The client and the next peer share the group in the test, so the next
+	// peer would wait for the load of the client from the slow owner unless
+	// it loads the hedged key itself.
+	g := mayflycache.NewGroup("hedge-http", 2<<10, localGetter(),
+		mayflycache.WithHedging(20*time.Millisecond), mayflycache.WithHotCache(2<<10, 0))
+	client := mayflycache.NewHTTPPool("http://client")
+	client.Set(owner.URL, next.URL)
+	g.RegisterPeers(client)
+	key := pickKey(t, client, owner.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if v, err := g.Get(ctx, key); err != nil || v.String() != "local-"+key {
+		t.Fatalf("expect local-%s from the hedge peer, but %v (%v) got", key, v, err)
+	}
+	// The hedge peer doesn't own the key, so it keeps the value in the hotCache
+	if s := g.Stats(); s.HedgeWins != 1 || s.MainCache.Items != 0 || s.HotCache.Items != 1 {
+		t.Fatalf("expect the hedge peer to win, but %+v got", s)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	g := mayflycache.NewGroup("retry", 2<<10, localGetter(), mayflycache.WithRetries(3, 5*time.Millisecond))
+	peer := &slowPeer{failures: 2}
+	g.RegisterPeers(peer)
+	if v, err := g.Get(context.Background(), "Name"); err != nil || v.String() != "peer-Name" {
+		t.Fatalf("expect peer-Name, but %v (%v) got", v, err)
+	}
+	if s := g.Stats(); peer.calls != 3 || s.Retries != 2 || s.PeerErrors != 0 {
+		t.Fatalf("expect 2 retries, but %d calls and %+v got", peer.calls, s)
+	}
+
+	// The retries are bounded, then it falls back to the Getter
+	bounded := mayflycache.NewGroup("retry-bounded", 2<<10, localGetter(), mayflycache.WithRetries(1, 5*time.Millisecond))
+	peer = &slowPeer{failures: 5}
+	bounded.RegisterPeers(peer)
+	if v, err := bounded.Get(context.Background(), "Name"); err != nil || v.String() != "local-Name" {
+		t.Fatalf("expect local-Name, but %v (%v) got", v, err)
+	}
+	if s := bounded.Stats(); peer.calls != 2 || s.Retries != 1 || s.PeerErrors != 1 {
+		t.Fatalf("expect 1 retry, but %d calls and %+v got", peer.calls, s)
+	}
+
+	// The keys not found are not retried
+	missing := mayflycache.NewGroup("retry-missing", 2<<10, localGetter(), mayflycache.WithRetries(3, 5*time.Millisecond))
+	notFound := &notFoundPeer{}
+	missing.RegisterPeers(notFound)
+	if _, err := missing.Get(context.Background(), "Name"); !errors.Is(err, mayflycache.ErrNotFound) || notFound.calls != 1 {
+		t.Fatalf("expect ErrNotFound without retries, but %v and %d calls got", err, notFound.calls)
+	}
+}
+
+func TestHTTPPoolPickHedge(t *testing.T) {
+	hp := mayflycache.NewHTTPPool("http://client")
+	hp.Set("http://a", "http://b")
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		owner, _ := hp.PickPeer(key)
+		hedge, ok := hp.PickHedge(key, owner)
+		if !ok || hedge == owner {
+			t.Fatalf("expect the other peer to hedge %s owned by %v, but %v got", key, owner, hedge)
+		}
+	}
+
+	// The current node next to the owner means loading locally
+	hp.Set("http://a", "http://client")
+	key := pickKey(t, hp, "http://a")
+	owner, _ := hp.PickPeer(key)
+	if hedge, ok := hp.PickHedge(key, owner); ok {
+		t.Fatalf("expect to hedge locally, but %v got", hedge)
+	}
+}
@@ -1,13 +1,20 @@
-package main
+package mayflycache
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/hey-kong/mayflycache/consistenthash"
 	pb "github.com/hey-kong/mayflycache/mayflycachepb"
@@ -15,8 +22,12 @@ import (
 )
 
 const (
-	defaultBasePath = "/_mayflycache/"
-	defaultReplicas = 50
+	defaultBasePath            = "/_mayflycache/"
+	healthPath                 = "/healthz"
+	detourHeader               = "X-Mayflycache-Detour" // set on the reads routed around the owners of the keys
+	defaultReplicas            = 50
+	defaultMaxIdleConnsPerHost = 32
+	defaultDialTimeout         = 30 * time.Second
 )
 
 // A HTTPPool represents the HTTP server structure, it implements
@@ -25,22 +36,187 @@ type HTTPPool struct {
 	self        string // for log output and verifying the service
 	basePath    string // equal to defaultBasePath
 	mu          sync.Mutex
-	peers       *consistenthash.Map    // consistent hash
-	httpGetters map[string]*httpGetter // map node name to httpGetter
+	peers       consistenthash.Placement // consistent hash
+	httpGetters map[string]*httpGetter   // map node name to httpGetter
+
+	client    *http.Client      // sends the requests to the peers
+	transport *http.Transport   // tuned by the options if neither client nor roundTripper is given
+	rt        http.RoundTripper // custom transport of the client, nil means transport
+	timeout   time.Duration     // timeout of each request to a peer, 0 means no timeout
+
+	threshold      int           // consecutive failures to open the circuit of a peer, 0 means no breaker
+	cooldown       time.Duration // interval of the trial requests to a peer with the open circuit
+	healthInterval time.Duration // interval of the health probes, 0 means no probes
+	stopHealth     chan struct{}
+
+	replication  int                             // number of the replicas of each key, including the owner
+	weights      map[string]int                  // weights of the peers on the hash ring, 1 by default
+	newPlacement func() consistenthash.Placement // creates the placement of the peers
+
+	epsilon  float64   // the load of a peer is bounded by 1+epsilon times the average, 0 means no bound
+	inflight AtomicInt // requests in flight to all the peers
+}
+
+// A HTTPPoolOption configures the HTTPPool in NewHTTPPool.
+type HTTPPoolOption func(*HTTPPool)
+
+// WithHTTPClient makes the HTTPPool send the requests to the peers by
+// the client, the transport options are ignored then.
+func WithHTTPClient(client *http.Client) HTTPPoolOption {
+	return func(hp *HTTPPool) {
+		hp.client = client
+	}
+}
+
+// WithRoundTripper makes the HTTPPool send the requests to the peers
+// through rt, the transport options are ignored then.
+func WithRoundTripper(rt http.RoundTripper) HTTPPoolOption {
+	return func(hp *HTTPPool) {
+		hp.rt = rt
+	}
+}
+
+// WithRequestTimeout bounds each request to a peer by timeout, so a hung
+// peer fails the request instead of blocking the load of the key.
+func WithRequestTimeout(timeout time.Duration) HTTPPoolOption {
+	return func(hp *HTTPPool) {
+		hp.timeout = timeout
+	}
+}
+
+// WithMaxIdleConnsPerPeer keeps at most n idle connections to each peer
+// for reuse, defaultMaxIdleConnsPerHost by default.
+func WithMaxIdleConnsPerPeer(n int) HTTPPoolOption {
+	return func(hp *HTTPPool) {
+		hp.transport.MaxIdleConnsPerHost = n
+	}
+}
+
+// WithKeepAlive sets the period of the TCP keep-alive probes of the
+// connections to the peers, a negative period disables them, and closes
+// the connections idle for idleTimeout, 0 means no limit.
+func WithKeepAlive(period, idleTimeout time.Duration) HTTPPoolOption {
+	return func(hp *HTTPPool) {
+		dialer := &net.Dialer{
+			Timeout:   defaultDialTimeout,
+			KeepAlive: period,
+		}
+		hp.transport.DialContext = dialer.DialContext
+		hp.transport.IdleConnTimeout = idleTimeout
+	}
+}
+
+// WithCircuitBreaker opens the circuit of a peer after threshold
+// consecutive failed requests, PickPeer skips the peer then and its keys
+// are routed to the next peer on the hash ring, while Set and Remove are
+// still sent to the owner and fail. A trial request is let through every
+// cooldown, the circuit is closed again if it succeeds.
+// Only the failures to reach the peer count, not the errors it returns.
+//
+// The next peer loads the keys of an open circuit by its Getter, and
+// both it and the requesting node keep them in the hotCache only, so the
+// Groups should be created WithHotCache, otherwise every read of the
+// keys goes to the origin.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) HTTPPoolOption {
+	return func(hp *HTTPPool) {
+		hp.threshold = threshold
+		hp.cooldown = cooldown
+	}
 }
 
-func NewHTTPPool(self string) *HTTPPool {
-	return &HTTPPool{
-		self:     self,
-		basePath: defaultBasePath,
+// WithHealthCheck probes the healthPath of each peer every interval in
+// background, a failed probe opens the circuit of the peer at once and
+// a succeeded one closes it. Call StopHealthCheck to stop probing.
+func WithHealthCheck(interval time.Duration) HTTPPoolOption {
+	return func(hp *HTTPPool) {
+		hp.healthInterval = interval
 	}
 }
 
+// WithReplication keeps each key on n successive peers on the hash ring,
+// the owner pushes the values it loads to the other n-1 replicas, and the
+// other peers read the key from any of them.
+func WithReplication(n int) HTTPPoolOption {
+	return func(hp *HTTPPool) {
+		hp.replication = n
+	}
+}
+
+// WithPeerWeights gives the peers shares of the keys in proportion to
+// their weights, e.g. a peer with 8 times the memory of the others can be
+// given the weight 8. The peers not in weights have the weight 1.
+func WithPeerWeights(weights map[string]int) HTTPPoolOption {
+	return func(hp *HTTPPool) {
+		hp.weights = weights
+	}
+}
+
+// WithPlacement places the keys on the peers by the Placement created
+// with newPlacement instead of the hash ring with defaultReplicas virtual
+// nodes per peer, e.g.
+//
+//	WithPlacement(func() consistenthash.Placement { return consistenthash.NewMaglev(0) })
+func WithPlacement(newPlacement func() consistenthash.Placement) HTTPPoolOption {
+	return func(hp *HTTPPool) {
+		hp.newPlacement = newPlacement
+	}
+}
+
+// WithBoundedLoad bounds the requests in flight to each peer by 1+epsilon
+// times the average, e.g. 0.25, the reads of the keys of a peer at its
+// bound walk to the next peer, so a few hot keys don't overload their
+// owner. The peer picked instead loads the key by its Getter and keeps it
+// in the hotCache, so the groups should be created WithHotCache. Set and
+// Remove are still sent to the owner.
+func WithBoundedLoad(epsilon float64) HTTPPoolOption {
+	return func(hp *HTTPPool) {
+		hp.epsilon = epsilon
+	}
+}
+
+// NewHTTPPool initializes an HTTP pool of peers, self is the base URL of
+// the current node, e.g. "http://localhost:8001".
+func NewHTTPPool(self string, opts ...HTTPPoolOption) *HTTPPool {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	hp := &HTTPPool{
+		self:        self,
+		basePath:    defaultBasePath,
+		transport:   transport,
+		replication: 1,
+		newPlacement: func() consistenthash.Placement {
+			return consistenthash.New(defaultReplicas, nil)
+		},
+	}
+	for _, opt := range opts {
+		opt(hp)
+	}
+	if hp.client == nil {
+		rt := hp.rt
+		if rt == nil {
+			rt = hp.transport
+		}
+		hp.client = &http.Client{Transport: rt}
+	}
+	if hp.healthInterval > 0 {
+		hp.stopHealth = make(chan struct{})
+		go hp.checkHealth(hp.healthInterval, hp.stopHealth)
+	}
+	return hp
+}
+
+// Log prints the log with the server name.
 func (hp *HTTPPool) Log(format string, v ...interface{}) {
 	log.Printf("[Server %s]%s", hp.self, fmt.Sprintf(format, v...))
 }
 
+// ServeHTTP handles all peer requests.
 func (hp *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The health probes of the peers are answered quietly
+	if r.URL.Path == healthPath {
+		w.Write([]byte("ok"))
+		return
+	}
 	hp.Log("%s %s", r.Method, r.URL.Path)
 
 	// Serve only '/_mayflycache/*' requests
@@ -64,14 +240,83 @@ func (hp *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	value, err := group.Get(key)
+	switch r.Method {
+	case http.MethodDelete:
+		// A DELETE request invalidates the key on this node only,
+		// the broadcast is done by the node calling Group.Remove.
+		group.removeLocally(key)
+		hp.writeProto(w, &pb.DeleteResponse{})
+		return
+	case http.MethodPost:
+		// A POST request carries a BatchRequest of the keys in the group,
+		// the errors of the keys are written in the response.
+		req := &pb.BatchRequest{}
+		if err := readProto(r, req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var values []Chunk
+		var errs []error
+		if r.Header.Get(detourHeader) != "" {
+			values, errs = group.getManyDetour(r.Context(), req.Keys)
+		} else {
+			values, errs = group.GetMany(r.Context(), req.Keys)
+		}
+		hp.writeProto(w, batchResponse(values, errs))
+		return
+	case http.MethodPut:
+		// A PUT request carries a SetRequest for the key this node owns
+		req := &pb.SetRequest{}
+		if err := readProto(r, req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var expire time.Time
+		if req.Expire != 0 {
+			expire = time.Unix(0, req.Expire)
+		}
+		group.setLocally(key, NewChunkWithExpire(req.Value, expire))
+		hp.writeProto(w, &pb.SetResponse{})
+		return
+	}
+
+	var value Chunk
+	var err error
+	if r.Header.Get(detourHeader) != "" {
+		value, err = group.getDetour(r.Context(), key)
+	} else {
+		value, err = group.Get(r.Context(), key)
+	}
+	if errors.Is(err, ErrNotFound) {
+		// The missing key is not an error of the server
+		hp.writeProto(w, &pb.Response{NotFound: true})
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Write the value to the response body as a proto message
-	body, err := proto.Marshal(&pb.Response{Value: value.ByteSlice()})
+	res := &pb.Response{Value: value.ByteSlice()}
+	if e := value.Expire(); !e.IsZero() {
+		res.Expire = e.UnixNano()
+	}
+	hp.writeProto(w, res)
+}
+
+// readProto decodes the message from the request body.
+func readProto(r *http.Request, m proto.Message) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(body, m)
+}
+
+// writeProto writes the message to the response body.
+func (hp *HTTPPool) writeProto(w http.ResponseWriter, m proto.Message) {
+	body, err := proto.Marshal(m)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -86,59 +331,393 @@ func (hp *HTTPPool) Set(peers ...string) {
 	hp.mu.Lock()
 	defer hp.mu.Unlock()
 
-	hp.peers = consistenthash.New(defaultReplicas, nil)
-	hp.peers.Set(peers...)
+	old := hp.httpGetters
+	hp.peers = hp.newPlacement()
 	hp.httpGetters = make(map[string]*httpGetter, len(peers))
+	hp.addPeers(peers)
+	for peer := range old {
+		if _, ok := hp.httpGetters[peer]; !ok {
+			forgetPeer(peer)
+		}
+	}
+}
+
+// AddPeers adds the peers to the pool without rebuilding the hash ring,
+// the peers that are already in the pool are ignored.
+func (hp *HTTPPool) AddPeers(peers ...string) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	if hp.peers == nil {
+		hp.peers = hp.newPlacement()
+		hp.httpGetters = make(map[string]*httpGetter, len(peers))
+	}
+	hp.addPeers(peers)
+}
+
+// addPeers must be called with hp.mu held.
+func (hp *HTTPPool) addPeers(peers []string) {
+	weights := make(map[string]int, len(peers))
 	for _, peer := range peers {
+		if _, ok := hp.httpGetters[peer]; ok {
+			continue
+		}
+		weights[peer] = hp.weights[peer]
 		hp.httpGetters[peer] = &httpGetter{
+			peer:    peer,
 			baseURL: peer + hp.basePath,
+			pool:    hp,
+			client:  hp.client,
+			timeout: hp.timeout,
+			health: &breaker{
+				threshold: hp.threshold,
+				cooldown:  hp.cooldown,
+			},
 		}
 	}
+	hp.peers.SetWeighted(weights)
+}
+
+// RemovePeers removes the peers from the pool, their keys are
+// taken over by the successors on the hash ring.
+func (hp *HTTPPool) RemovePeers(peers ...string) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	if hp.peers == nil {
+		return
+	}
+	for _, peer := range peers {
+		if _, ok := hp.httpGetters[peer]; !ok {
+			continue
+		}
+		hp.peers.Remove(peer)
+		delete(hp.httpGetters, peer)
+		forgetPeer(peer)
+	}
+}
+
+// Peers returns the sorted peers in the pool, including the current node.
+func (hp *HTTPPool) Peers() []string {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	peers := make([]string, 0, len(hp.httpGetters))
+	for peer := range hp.httpGetters {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
 }
 
 // PickPeer implements PeerPicker interface for HTTPPool to return the httpGetter according to the key.
 func (hp *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
 	hp.mu.Lock()
 	defer hp.mu.Unlock()
-	if peer := hp.peers.Get(key); peer != "" && peer != hp.self {
+	if hp.peers == nil {
+		return nil, false
+	}
+	// The peers with the open circuit or at the bound of the load are skipped
+	peer := hp.peers.GetFunc(key, hp.acceptor(nil))
+	if peer != "" && peer != hp.self {
 		hp.Log("Pick peer %s", peer)
 		return hp.httpGetters[peer], true
 	}
 	return nil, false
 }
 
+// PickOwner implements OwnerPicker interface for HTTPPool to return the
+// httpGetter of the owner of the key on the hash ring, even if its
+// circuit is open.
+func (hp *HTTPPool) PickOwner(key string) (PeerGetter, bool) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	if hp.peers == nil {
+		return nil, false
+	}
+	if peer := hp.peers.Get(key); peer != "" && peer != hp.self {
+		return hp.httpGetters[peer], true
+	}
+	return nil, false
+}
+
+// acceptor returns the function accepting the current node or the peers
+// other than skip which are healthy and below the bound of the load, it
+// must be called with hp.mu held. The load of the current node is not
+// known, so the bound is the average of the other peers.
+func (hp *HTTPPool) acceptor(skip PeerGetter) func(peer string) bool {
+	bound := int64(0)
+	if hp.epsilon > 0 {
+		peers := len(hp.httpGetters)
+		if _, ok := hp.httpGetters[hp.self]; ok {
+			peers--
+		}
+		bound = int64(consistenthash.BoundedLoad(int(hp.inflight.Get()), peers, hp.epsilon))
+	}
+	return func(peer string) bool {
+		if peer == hp.self {
+			return true
+		}
+		getter := hp.httpGetters[peer]
+		// The breaker is asked last, as it lets a single trial through
+		return getter != skip && (bound == 0 || getter.inflight.Get() < bound) && getter.health.allow()
+	}
+}
+
+// Loads returns the requests in flight to each peer except the current node.
+func (hp *HTTPPool) Loads() map[string]int64 {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	loads := make(map[string]int64, len(hp.httpGetters))
+	for peer, getter := range hp.httpGetters {
+		if peer != hp.self {
+			loads[peer] = getter.inflight.Get()
+		}
+	}
+	return loads
+}
+
+// PickHedge implements HedgePicker interface for HTTPPool to return the
+// httpGetter of the next healthy peer after the owner on the hash ring.
+func (hp *HTTPPool) PickHedge(key string, owner PeerGetter) (PeerGetter, bool) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	if hp.peers == nil {
+		return nil, false
+	}
+	peer := hp.peers.GetFunc(key, hp.acceptor(owner))
+	if peer != "" && peer != hp.self {
+		hp.Log("Pick hedge peer %s", peer)
+		return hp.httpGetters[peer], true
+	}
+	return nil, false
+}
+
+// PickReplicas implements ReplicaPicker interface for HTTPPool to return
+// the httpGetters of the replicas of the key, the peers with the open
+// circuit are left out.
+func (hp *HTTPPool) PickReplicas(key string) (peers []PeerGetter, self bool) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	if hp.peers == nil || hp.replication < 2 {
+		return nil, false
+	}
+	for _, peer := range hp.peers.GetN(key, hp.replication) {
+		if peer == hp.self {
+			self = true
+		} else if getter := hp.httpGetters[peer]; getter.health.current() != circuitOpen {
+			peers = append(peers, getter)
+		}
+	}
+	return peers, self
+}
+
+// GetAll implements PeerPicker interface for HTTPPool to return the httpGetters of other peers.
+func (hp *HTTPPool) GetAll() []PeerGetter {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	var peers []PeerGetter
+	for peer, getter := range hp.httpGetters {
+		if peer != hp.self {
+			peers = append(peers, getter)
+		}
+	}
+	return peers
+}
+
+// Health returns the circuit state of each peer except the current node,
+// "closed", "open" or "half-open".
+func (hp *HTTPPool) Health() map[string]string {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	health := make(map[string]string, len(hp.httpGetters))
+	for peer, getter := range hp.httpGetters {
+		if peer != hp.self {
+			health[peer] = getter.health.current().String()
+		}
+	}
+	return health
+}
+
+// StopHealthCheck stops the health probes started by WithHealthCheck.
+func (hp *HTTPPool) StopHealthCheck() {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	if hp.stopHealth != nil {
+		close(hp.stopHealth)
+		hp.stopHealth = nil
+	}
+}
+
+// checkHealth probes the peers every interval until stop is closed.
+func (hp *HTTPPool) checkHealth(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			hp.probePeers(interval)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// probePeers probes all the peers concurrently, so a hung peer
+// doesn't delay the others.
+func (hp *HTTPPool) probePeers(interval time.Duration) {
+	timeout := hp.timeout
+	if timeout <= 0 || timeout > interval {
+		timeout = interval
+	}
+	var wg sync.WaitGroup
+	for _, peer := range hp.GetAll() {
+		wg.Add(1)
+		go func(getter *httpGetter) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if err := getter.probe(ctx); err != nil {
+				if getter.health.current() != circuitOpen {
+					hp.Log("Peer %s is unhealthy: %v", getter.peer, err)
+				}
+				getter.health.trip()
+				return
+			}
+			getter.health.success()
+		}(peer.(*httpGetter))
+	}
+	wg.Wait()
+}
+
 // httpGetter is an implementation of PeerGetter on HTTP protocol.
 type httpGetter struct {
-	baseURL string
+	peer     string // base URL of the peer, e.g. "http://localhost:8001"
+	baseURL  string
+	pool     *HTTPPool
+	inflight AtomicInt // requests in flight to the peer
+	client   *http.Client
+	timeout  time.Duration // timeout of each request, 0 means no timeout
+	health   *breaker
+}
+
+// String returns the peer name used in the metrics.
+func (hp *httpGetter) String() string {
+	return hp.peer
 }
 
 // Get uses baseURL, group and key to splice request URL,
-// and calls http.Get to get data from a group.
-func (hp *httpGetter) Get(in *pb.Request, out *pb.Response) error {
+// and sends a request bound to ctx to get data from a group.
+// A detour, e.g. a hedged request, asks the peer to load the key rather
+// than send it to the owner.
+func (hp *httpGetter) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	var header http.Header
+	if isDetour(ctx) {
+		header = http.Header{detourHeader: {"1"}}
+	}
+	return hp.do(ctx, http.MethodGet, in.GetGroup(), in.GetKey(), header, nil, out)
+}
+
+// GetMany sends a POST request of the keys in the group to get their values
+// in one round trip, a detour asks the peer to load the keys like Get.
+func (hp *httpGetter) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	var header http.Header
+	if isDetour(ctx) {
+		header = http.Header{detourHeader: {"1"}}
+	}
+	return hp.do(ctx, http.MethodPost, in.GetGroup(), "", header, in, out)
+}
+
+// Set sends a PUT request to store the value on the peer.
+func (hp *httpGetter) Set(ctx context.Context, in *pb.SetRequest) error {
+	return hp.do(ctx, http.MethodPut, in.GetGroup(), in.GetKey(), nil, in, &pb.SetResponse{})
+}
+
+// Delete sends a DELETE request to invalidate the key on the peer.
+func (hp *httpGetter) Delete(ctx context.Context, in *pb.Request) error {
+	return hp.do(ctx, http.MethodDelete, in.GetGroup(), in.GetKey(), nil, nil, &pb.DeleteResponse{})
+}
+
+// do sends the request of the key in the group with the optional header,
+// in is the optional message of the request body, and out is decoded from
+// the response body. The request is abandoned when ctx is done or the
+// timeout expires.
+func (hp *httpGetter) do(ctx context.Context, method, group, key string, header http.Header, in, out proto.Message) error {
+	hp.inflight.Add(1)
+	hp.pool.inflight.Add(1)
+	defer func() {
+		hp.inflight.Add(-1)
+		hp.pool.inflight.Add(-1)
+	}()
+
+	parent := ctx
+	if hp.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hp.timeout)
+		defer cancel()
+	}
 	u := fmt.Sprintf(
 		"%v%v/%v",
 		hp.baseURL,
-		url.QueryEscape(in.GetGroup()),
-		url.QueryEscape(in.GetKey()),
+		url.QueryEscape(group),
+		url.QueryEscape(key),
 	)
-	res, err := http.Get(u)
+	var body io.Reader
+	if in != nil {
+		b, err := proto.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
 	if err != nil {
 		return err
 	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	res, err := hp.client.Do(req)
+	if err != nil {
+		// The failures caused by the caller giving up don't count
+		if parent.Err() == nil {
+			hp.health.failure()
+		}
+		return err
+	}
 	defer res.Body.Close()
+	hp.health.success()
 
 	if res.StatusCode != http.StatusOK {
 		return fmt.Errorf("Server returned: %v\n", res.Status)
 	}
 
-	bytes, err := ioutil.ReadAll(res.Body)
+	b, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return fmt.Errorf("error when reading response body: %v", err)
 	}
 
-	if err = proto.Unmarshal(bytes, out); err != nil {
+	if err = proto.Unmarshal(b, out); err != nil {
 		return fmt.Errorf("decoding response body: %v", err)
 	}
 
 	return nil
 }
+
+// probe sends a request to the healthPath of the peer.
+func (hp *httpGetter) probe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hp.peer+healthPath, nil)
+	if err != nil {
+		return err
+	}
+	res, err := hp.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned: %v", res.Status)
+	}
+	return nil
+}
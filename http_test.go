@@ -0,0 +1,512 @@
+package mayflycache_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hey-kong/mayflycache"
+	"github.com/hey-kong/mayflycache/consistenthash"
+	pb "github.com/hey-kong/mayflycache/mayflycachepb"
+)
+
+// slowServer responds with an empty message after delay, a negative delay
+// hangs until the request is abandoned. It counts the new connections.
+func slowServer(delay time.Duration, conns *int32) *httptest.Server {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if delay < 0 {
+			<-r.Context().Done()
+			return
+		}
+		time.Sleep(delay)
+		w.Write([]byte{})
+	}))
+	ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew && conns != nil {
+			atomic.AddInt32(conns, 1)
+		}
+	}
+	ts.Start()
+	return ts
+}
+
+func TestHTTPPoolTimeout(t *testing.T) {
+	hung := slowServer(-1, nil)
+	defer hung.Close()
+	slow := slowServer(20*time.Millisecond, nil)
+	defer slow.Close()
+
+	client := mayflycache.NewHTTPPool("http://client", mayflycache.WithRequestTimeout(100*time.Millisecond))
+	client.Set(hung.URL)
+	peer, _ := client.PickPeer("Name")
+	start := time.Now()
+	err := peer.Get(context.Background(), &pb.Request{Group: "timeout", Key: "Name"}, &pb.Response{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect the deadline to be exceeded, but %v got", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("expect the hung peer to fail after the timeout, but %v got", d)
+	}
+
+	client.Set(slow.URL)
+	peer, _ = client.PickPeer("Name")
+	if err := peer.Get(context.Background(), &pb.Request{Group: "timeout", Key: "Name"}, &pb.Response{}); err != nil {
+		t.Fatalf("expect the slow peer within the timeout to succeed, but %v got", err)
+	}
+}
+
+func TestGroupHungPeer(t *testing.T) {
+	hung := slowServer(-1, nil)
+	defer hung.Close()
+
+	g := mayflycache.NewGroup("hung-peer", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) { return []byte("local-" + key), nil },
+	))
+	client := mayflycache.NewHTTPPool("http://client", mayflycache.WithRequestTimeout(50*time.Millisecond))
+	client.Set(hung.URL)
+	g.RegisterPeers(client)
+
+	// The load falls back to the Getter after the peer times out
+	if v, err := g.Get(context.Background(), "Name"); err != nil || v.String() != "local-Name" {
+		t.Fatalf("expect local-Name, but %v (%v) got", v, err)
+	}
+	if s := g.Stats(); s.PeerErrors != 1 || s.LocalLoads != 1 {
+		t.Fatalf("expect 1 peer error and 1 local load, but %+v got", s)
+	}
+}
+
+// countingTransport counts the requests sent through it.
+type countingTransport struct {
+	requests int32
+}
+
+func (ct *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&ct.requests, 1)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestHTTPPoolClient(t *testing.T) {
+	ts := slowServer(0, nil)
+	defer ts.Close()
+
+	ct := &countingTransport{}
+	client := mayflycache.NewHTTPPool("http://client", mayflycache.WithRoundTripper(ct))
+	client.Set(ts.URL)
+	peer, _ := client.PickPeer("Name")
+	peer.Get(context.Background(), &pb.Request{Group: "client", Key: "Name"}, &pb.Response{})
+	if n := atomic.LoadInt32(&ct.requests); n != 1 {
+		t.Fatalf("expect 1 request through the RoundTripper, but %d got", n)
+	}
+
+	other := &countingTransport{}
+	client = mayflycache.NewHTTPPool("http://client",
+		mayflycache.WithHTTPClient(&http.Client{Transport: other}), mayflycache.WithRoundTripper(ct))
+	client.Set(ts.URL)
+	peer, _ = client.PickPeer("Name")
+	peer.Get(context.Background(), &pb.Request{Group: "client", Key: "Name"}, &pb.Response{})
+	if n := atomic.LoadInt32(&other.requests); n != 1 {
+		t.Fatalf("expect 1 request through the http.Client, but %d got", n)
+	}
+}
+
+func TestHTTPPoolKeepAlive(t *testing.T) {
+	var conns int32
+	ts := slowServer(0, &conns)
+	defer ts.Close()
+
+	client := mayflycache.NewHTTPPool("http://client",
+		mayflycache.WithMaxIdleConnsPerPeer(4), mayflycache.WithKeepAlive(time.Minute, 50*time.Millisecond))
+	client.Set(ts.URL)
+	peer, _ := client.PickPeer("Name")
+	get := func() {
+		if err := peer.Get(context.Background(), &pb.Request{Group: "keep-alive", Key: "Name"}, &pb.Response{}); err != nil {
+			t.Fatalf("get from peer failed: %v", err)
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		get()
+	}
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Fatalf("expect the connection to be reused, but %d connections got", n)
+	}
+
+	// The idle connection is closed after the idle timeout
+	time.Sleep(100 * time.Millisecond)
+	get()
+	if n := atomic.LoadInt32(&conns); n != 2 {
+		t.Fatalf("expect a new connection after the idle timeout, but %d connections got", n)
+	}
+}
+
+// flakyServer serves an empty message and the health probes, while down
+// is set it drops the connections and fails the probes.
+func flakyServer(down *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(down) == 0 {
+			w.Write([]byte{})
+			return
+		}
+		if r.URL.Path == "/healthz" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+}
+
+// pickKey returns a key owned by the peer in the pool.
+func pickKey(t *testing.T, hp *mayflycache.HTTPPool, peer string) string {
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if p, ok := hp.PickPeer(key); ok && fmt.Sprint(p) == peer {
+			return key
+		}
+	}
+	t.Fatalf("no key is owned by %s", peer)
+	return ""
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var down int32
+	flaky := flakyServer(&down)
+	defer flaky.Close()
+	alive := slowServer(0, nil)
+	defer alive.Close()
+
+	client := mayflycache.NewHTTPPool("http://client", mayflycache.WithCircuitBreaker(2, 50*time.Millisecond))
+	client.Set(flaky.URL, alive.URL)
+	key := pickKey(t, client, flaky.URL)
+	get := func() error {
+		peer, _ := client.PickPeer(key)
+		return peer.Get(context.Background(), &pb.Request{Group: "breaker", Key: key}, &pb.Response{})
+	}
+
+	atomic.StoreInt32(&down, 1)
+	for i := 0; i < 2; i++ {
+		if err := get(); err == nil {
+			t.Fatalf("expect the request to the down peer to fail")
+		}
+	}
+	// The keys of the down peer are routed to the next one on the ring
+	if peer, _ := client.PickPeer(key); fmt.Sprint(peer) != alive.URL {
+		t.Fatalf("expect %s to be skipped, but %v picked", flaky.URL, peer)
+	}
+	if h := client.Health(); h[flaky.URL] != "open" || h[alive.URL] != "closed" {
+		t.Fatalf("expect the circuit of %s to be open, but %v got", flaky.URL, h)
+	}
+
+	// A single trial after the cooldown, which fails and opens the circuit again
+	time.Sleep(60 * time.Millisecond)
+	if err := get(); err == nil {
+		t.Fatalf("expect the trial request to fail")
+	}
+	if h := client.Health(); h[flaky.URL] != "open" {
+		t.Fatalf("expect the circuit to be opened again, but %v got", h)
+	}
+
+	atomic.StoreInt32(&down, 0)
+	time.Sleep(60 * time.Millisecond)
+	trial, _ := client.PickPeer(key)
+	if fmt.Sprint(trial) != flaky.URL {
+		t.Fatalf("expect a trial request to %s, but %v picked", flaky.URL, trial)
+	}
+	if peer, _ := client.PickPeer(key); fmt.Sprint(peer) != alive.URL {
+		t.Fatalf("expect only one trial request, but %v picked", peer)
+	}
+	if err := trial.Get(context.Background(), &pb.Request{Group: "breaker", Key: key}, &pb.Response{}); err != nil {
+		t.Fatalf("expect the request to the recovered peer to succeed, but %v got", err)
+	}
+	if h := client.Health(); h[flaky.URL] != "closed" {
+		t.Fatalf("expect the circuit to be closed, but %v got", h)
+	}
+}
+
+func TestCircuitBreakerWrites(t *testing.T) {
+	var down int32
+	flaky := flakyServer(&down)
+	defer flaky.Close()
+	alive := httptest.NewServer(mayflycache.NewHTTPPool("http://alive"))
+	defer alive.Close()
+
+	client := mayflycache.NewHTTPPool("http://client", mayflycache.WithCircuitBreaker(1, time.Minute))
+	client.Set(flaky.URL, alive.URL)
+	g := mayflycache.NewGroup("breaker-writes", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			return []byte(key), nil
+		},
+	))
+	g.RegisterPeers(client)
+	key := pickKey(t, client, flaky.URL)
+
+	atomic.StoreInt32(&down, 1)
+	peer, _ := client.PickPeer(key)
+	peer.Get(context.Background(), &pb.Request{Group: "breaker-writes", Key: key}, &pb.Response{})
+	if owner, _ := client.PickOwner(key); fmt.Sprint(owner) != flaky.URL {
+		t.Fatalf("expect %s to own the key, but %v picked", flaky.URL, owner)
+	}
+
+	// The write fails rather than being stored on alive, which the
+	// owner would not know of after it recovers
+	if err := g.Set(context.Background(), key, []byte("new"), 0); err == nil {
+		t.Fatalf("expect the write to the down owner to fail")
+	}
+	if n := g.Stats().MainCache.Items; n != 0 {
+		t.Fatalf("expect nothing stored on %s, but %d items got", alive.URL, n)
+	}
+}
+
+func TestCircuitBreakerHotCache(t *testing.T) {
+	var down int32
+	flaky := flakyServer(&down)
+	defer flaky.Close()
+	var next recorder
+	nextServer := recordServer(&next, false)
+	defer nextServer.Close()
+
+	client := mayflycache.NewHTTPPool("http://client", mayflycache.WithCircuitBreaker(1, time.Minute))
+	client.Set(flaky.URL, nextServer.URL)
+	g := mayflycache.NewGroup("breaker-hot", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			return []byte(key), nil
+		},
+	), mayflycache.WithHotCache(2<<10, 0))
+	g.RegisterPeers(client)
+	key := pickKey(t, client, flaky.URL)
+
+	atomic.StoreInt32(&down, 1)
+	peer, _ := client.PickPeer(key)
+	peer.Get(context.Background(), &pb.Request{Group: "breaker-hot", Key: key}, &pb.Response{})
+
+	// The detoured value is kept even if the hotCache ratio is 0
+	for i := 0; i < 3; i++ {
+		if _, err := g.Get(context.Background(), key); err != nil {
+			t.Fatalf("expect the key to be detoured to %s, but %v got", nextServer.URL, err)
+		}
+	}
+	if n := atomic.LoadInt32(&next.detours); n != 1 {
+		t.Fatalf("expect 1 detour, but %d got", n)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	var down int32
+	flaky := flakyServer(&down)
+	defer flaky.Close()
+	alive := httptest.NewServer(mayflycache.NewHTTPPool("http://alive"))
+	defer alive.Close()
+
+	client := mayflycache.NewHTTPPool("http://client", mayflycache.WithHealthCheck(10*time.Millisecond))
+	defer client.StopHealthCheck()
+	client.Set(flaky.URL, alive.URL)
+	key := pickKey(t, client, flaky.URL)
+
+	atomic.StoreInt32(&down, 1)
+	if !waitFor(func() bool { return client.Health()[flaky.URL] == "open" }) {
+		t.Fatalf("expect the probes to open the circuit, but %v got", client.Health())
+	}
+	if peer, _ := client.PickPeer(key); fmt.Sprint(peer) != alive.URL {
+		t.Fatalf("expect %s to be skipped, but %v picked", flaky.URL, peer)
+	}
+	if h := client.Health(); h[alive.URL] != "closed" {
+		t.Fatalf("expect the pool to answer the probes, but %v got", h)
+	}
+
+	atomic.StoreInt32(&down, 0)
+	if !waitFor(func() bool { return client.Health()[flaky.URL] == "closed" }) {
+		t.Fatalf("expect the probes to close the circuit, but %v got", client.Health())
+	}
+}
+
+func TestHTTPPoolWeights(t *testing.T) {
+	peers := []string{"http://10.0.0.1:8001", "http://10.0.0.2:8001", "http://10.0.0.3:8001"}
+	hp := mayflycache.NewHTTPPool("http://client", mayflycache.WithPeerWeights(map[string]int{peers[2]: 4}))
+	hp.Set(peers...)
+
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		peer, _ := hp.PickPeer(fmt.Sprintf("key%d", i))
+		counts[fmt.Sprint(peer)]++
+	}
+	// The peer of weight 4 owns about 2/3 of the keys
+	if n := counts[peers[2]]; n < 5500 || n > 7800 {
+		t.Fatalf("expect about 6667 keys on the heavy peer, but %v got", counts)
+	}
+}
+
+func TestHTTPPoolPlacement(t *testing.T) {
+	placements := map[string]func() consistenthash.Placement{
+		"Jump":       func() consistenthash.Placement { return consistenthash.NewJump() },
+		"Rendezvous": func() consistenthash.Placement { return consistenthash.NewRendezvous() },
+		"Maglev":     func() consistenthash.Placement { return consistenthash.NewMaglev(0) },
+	}
+	peers := []string{"http://10.0.0.1:8001", "http://10.0.0.2:8001", "http://10.0.0.3:8001"}
+	for name, newPlacement := range placements {
+		hp := mayflycache.NewHTTPPool("http://client", mayflycache.WithPlacement(newPlacement))
+		hp.Set(peers...)
+		p := newPlacement()
+		p.Set(peers...)
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("key%d", i)
+			if peer, _ := hp.PickPeer(key); fmt.Sprint(peer) != p.Get(key) {
+				t.Fatalf("%s: expect %s to own %s, but %v got", name, p.Get(key), key, peer)
+			}
+		}
+	}
+}
+
+func TestHTTPPoolBoundedLoad(t *testing.T) {
+	var peers []string
+	for i := 0; i < 3; i++ {
+		hung := slowServer(-1, nil)
+		defer hung.Close()
+		peers = append(peers, hung.URL)
+	}
+	client := mayflycache.NewHTTPPool("http://client", mayflycache.WithBoundedLoad(0.25))
+	client.Set(peers...)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	total := func() int64 {
+		var total int64
+		for _, load := range client.Loads() {
+			total += load
+		}
+		return total
+	}
+
+	// The requests of a hot key stay in flight
+	const requests = 12
+	for i := 0; i < requests; i++ {
+		peer, _ := client.PickPeer("hot")
+		go peer.Get(ctx, &pb.Request{Group: "bounded", Key: "hot"}, &pb.Response{})
+		n := int64(i + 1)
+		if !waitFor(func() bool { return total() == n }) {
+			t.Fatalf("expect %d requests in flight, but %v got", n, client.Loads())
+		}
+	}
+	// ceil(1.25 * 12 / 3) = 5
+	for peer, load := range client.Loads() {
+		if load > 5 || load == 0 {
+			t.Fatalf("expect at most 5 requests in flight to %s, but %v got", peer, client.Loads())
+		}
+	}
+
+	cancel()
+	if !waitFor(func() bool { return total() == 0 }) {
+		t.Fatalf("expect no request in flight after canceling, but %v got", client.Loads())
+	}
+
+	// The load of the current node is not known, so it is left out of the average
+	client = mayflycache.NewHTTPPool("http://client", mayflycache.WithBoundedLoad(0.25))
+	client.Set("http://client", peers[0], peers[1])
+	key := pickKey(t, client, peers[0])
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	// ceil(1.25 * 2 / 2) = 2
+	for i := int64(1); i <= 2; i++ {
+		peer, _ := client.PickPeer(key)
+		if fmt.Sprint(peer) != peers[0] {
+			t.Fatalf("expect the request %d to go to the owner %s, but %v picked", i, peers[0], peer)
+		}
+		go peer.Get(ctx, &pb.Request{Group: "bounded", Key: key}, &pb.Response{})
+		if !waitFor(func() bool { return client.Loads()[peers[0]] == i }) {
+			t.Fatalf("expect %d requests in flight, but %v got", i, client.Loads())
+		}
+	}
+}
+
+// recorder counts the requests served by a recordServer.
+type recorder struct {
+	gets, detours, puts int32
+}
+
+// recordServer serves an empty message and counts the requests, the Get
+// requests hang until they are abandoned if hang is set.
+func recordServer(rec *recorder, hang bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut:
+			atomic.AddInt32(&rec.puts, 1)
+		case r.Header.Get("X-Mayflycache-Detour") != "":
+			atomic.AddInt32(&rec.detours, 1)
+		default:
+			atomic.AddInt32(&rec.gets, 1)
+		}
+		if hang && r.Method == http.MethodGet {
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte{})
+	}))
+}
+
+func TestHTTPPoolDetour(t *testing.T) {
+	var owner, other recorder
+	ownerServer := recordServer(&owner, true)
+	defer ownerServer.Close()
+	otherServer := recordServer(&other, false)
+	defer otherServer.Close()
+
+	client := mayflycache.NewHTTPPool("http://client", mayflycache.WithBoundedLoad(0.25))
+	client.Set(ownerServer.URL, otherServer.URL)
+	g := mayflycache.NewGroup("detour", 2<<10, localGetter())
+	g.RegisterPeers(client)
+	key := pickKey(t, client, ownerServer.URL)
+
+	// The requests to the owner stay in flight until it is at its bound
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := int64(1); ; i++ {
+		peer, _ := client.PickPeer(key)
+		if fmt.Sprint(peer) != ownerServer.URL {
+			break
+		}
+		if i > 10 {
+			t.Fatalf("expect the key to spill over, but %v got", client.Loads())
+		}
+		go peer.Get(ctx, &pb.Request{Group: "detour", Key: key}, &pb.Response{})
+		if !waitFor(func() bool { return client.Loads()[ownerServer.URL] == i }) {
+			t.Fatalf("expect %d requests in flight, but %v got", i, client.Loads())
+		}
+	}
+
+	// The other peer is asked to load the key rather than send it back to the owner
+	if _, err := g.Get(context.Background(), key); err != nil {
+		t.Fatalf("get from the other peer failed: %v", err)
+	}
+	if n := atomic.LoadInt32(&other.detours); n != 1 {
+		t.Fatalf("expect a detour to %s, but %d got", otherServer.URL, n)
+	}
+	// The writes are sent to the owner regardless of its load
+	if err := g.Set(context.Background(), key, []byte("new"), 0); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if atomic.LoadInt32(&owner.puts) != 1 || atomic.LoadInt32(&other.puts) != 0 {
+		t.Fatalf("expect the write to go to the owner, but %d and %d got", owner.puts, other.puts)
+	}
+
+	// The replicas are read as usual, a replica missing the key loads it from the owner
+	var a, b recorder
+	aServer := recordServer(&a, false)
+	defer aServer.Close()
+	bServer := recordServer(&b, false)
+	defer bServer.Close()
+	replicated := mayflycache.NewHTTPPool("http://client", mayflycache.WithReplication(2))
+	replicated.Set(aServer.URL, bServer.URL)
+	g = mayflycache.NewGroup("detour-replicas", 2<<10, localGetter())
+	g.RegisterPeers(replicated)
+	for i := 0; i < 20; i++ {
+		g.Get(context.Background(), fmt.Sprintf("key%d", i))
+	}
+	if a.detours != 0 || b.detours != 0 || a.gets == 0 || b.gets == 0 {
+		t.Fatalf("expect the replicas to be read without detours, but %+v and %+v got", a, b)
+	}
+}
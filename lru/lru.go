@@ -1,6 +1,9 @@
 package lru
 
-import "container/list"
+import (
+	"container/list"
+	"time"
+)
 
 type LRUCache struct {
 	maxBytes  int64 // maximum bytes of memory available
@@ -8,11 +11,25 @@ type LRUCache struct {
 	l         *list.List
 	m         map[string]*list.Element
 	onEvicted func(string, Value) // optional func, called when an Entry is deleted
+	stats     Stats
+}
+
+// Stats are the counters of the cache operations.
+type Stats struct {
+	Gets        int64 // calls of Get
+	Hits        int64 // calls of Get that found an unexpired entry
+	Evictions   int64 // entries removed to free memory for the new ones
+	Expirations int64 // expired entries removed
 }
 
 type Entry struct {
-	key   string
-	value Value
+	key    string
+	value  Value
+	expire time.Time // zero means the entry never expires
+}
+
+func (e Entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
 }
 
 func (e Entry) size() int64 {
@@ -34,27 +51,42 @@ func NewLRUCache(maxBytes int64, onEvicted func(string, Value)) *LRUCache {
 }
 
 func (lru *LRUCache) Get(key string) (value Value, done bool) {
+	lru.stats.Gets++
 	if e, ok := lru.m[key]; ok {
+		kv := e.Value.(*Entry)
+		// The expired entry is purged lazily when it is accessed
+		if kv.expired(time.Now()) {
+			lru.removeElement(e)
+			lru.stats.Expirations++
+			return
+		}
+
 		// If the cached value is used, it will be moved to the end of the list
 		lru.l.MoveToBack(e)
-
-		kv := e.Value.(*Entry)
 		value, done = kv.value, true
+		lru.stats.Hits++
 	}
 	return
 }
 
 func (lru *LRUCache) Set(key string, value Value) {
+	lru.SetWithExpire(key, value, time.Time{})
+}
+
+// SetWithExpire is like Set, but the entry is treated as missing
+// after expire, the zero time means it never expires.
+func (lru *LRUCache) SetWithExpire(key string, value Value, expire time.Time) {
 	if e, ok := lru.m[key]; ok {
 		// If the value already exists, move it to the end of the list and update the value
 		lru.l.MoveToBack(e)
 		kv := e.Value.(*Entry)
 		lru.curBytes -= kv.size()
 		kv.value = value
+		kv.expire = expire
 		lru.curBytes += kv.size()
 	} else {
 		// Otherwise, add a new entry
-		e := lru.l.PushBack(&Entry{key, value})
+		e := lru.l.PushBack(&Entry{key, value, expire})
 		lru.m[key] = e
 		lru.curBytes += e.Value.(*Entry).size()
 	}
@@ -69,17 +101,58 @@ func (lru *LRUCache) Set(key string, value Value) {
 func (lru *LRUCache) Remove() {
 	e := lru.l.Front()
 	if e != nil {
-		kv := e.Value.(*Entry)
 		// Removed the entry at the head of the list,
 		// the head of the list must be the LRU (least recently used) entry.
-		lru.l.Remove(e)
-		delete(lru.m, kv.key)
-		lru.curBytes -= kv.size()
-		// Call callback function
-		if lru.onEvicted != nil {
-			lru.onEvicted(kv.key, kv.value)
+		lru.removeElement(e)
+		lru.stats.Evictions++
+	}
+}
+
+// Delete removes the entry of the key, it reports whether the key was cached.
+func (lru *LRUCache) Delete(key string) bool {
+	if e, ok := lru.m[key]; ok {
+		lru.removeElement(e)
+		return true
+	}
+	return false
+}
+
+// RemoveExpired removes all the expired entries
+// and returns how many of them are removed.
+func (lru *LRUCache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for e := lru.l.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(*Entry).expired(now) {
+			lru.removeElement(e)
+			n++
 		}
+		e = next
 	}
+	lru.stats.Expirations += int64(n)
+	return n
+}
+
+func (lru *LRUCache) removeElement(e *list.Element) {
+	kv := e.Value.(*Entry)
+	lru.l.Remove(e)
+	delete(lru.m, kv.key)
+	lru.curBytes -= kv.size()
+	// Call callback function
+	if lru.onEvicted != nil {
+		lru.onEvicted(kv.key, kv.value)
+	}
+}
+
+// Bytes returns the bytes of memory in use.
+func (lru *LRUCache) Bytes() int64 {
+	return lru.curBytes
+}
+
+// Stats returns the counters of the cache operations.
+func (lru *LRUCache) Stats() Stats {
+	return lru.stats
 }
 
 // Len returns how many key-value entries are currently cached.
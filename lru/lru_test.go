@@ -3,6 +3,7 @@ package lru
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 type String string
@@ -47,6 +48,19 @@ func TestRemoveOldest(t *testing.T) {
 	}
 }
 
+func TestDelete(t *testing.T) {
+	lru := NewLRUCache(int64(0), nil)
+	lru.Set("key1", String("1234"))
+	lru.Set("key2", String("1234"))
+
+	if !lru.Delete("key1") || lru.Delete("key3") {
+		t.Fatalf("Delete should report whether the key was cached")
+	}
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 1 || lru.Bytes() != int64(len("key2")+len("1234")) {
+		t.Fatalf("Delete key1 failed")
+	}
+}
+
 func TestOnEvicted(t *testing.T) {
 	keys := make([]string, 0)
 	callback := func(key string, value Value) {
@@ -62,3 +76,49 @@ func TestOnEvicted(t *testing.T) {
 		t.Fatalf("Call OnEvicted failed, expect keys equals to %s", expect)
 	}
 }
+
+func TestExpire(t *testing.T) {
+	lru := NewLRUCache(int64(0), nil)
+	lru.SetWithExpire("key1", String("1234"), time.Now().Add(-time.Second))
+	lru.SetWithExpire("key2", String("1234"), time.Now().Add(time.Hour))
+	lru.Set("key3", String("1234"))
+
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 2 {
+		t.Fatalf("expired key1 should be purged when accessed")
+	}
+	if _, ok := lru.Get("key2"); !ok {
+		t.Fatalf("cache hit key2 failed")
+	}
+	if _, ok := lru.Get("key3"); !ok {
+		t.Fatalf("cache hit key3 failed")
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	lru := NewLRUCache(int64(0), nil)
+	lru.SetWithExpire("key1", String("1234"), time.Now().Add(-time.Second))
+	lru.SetWithExpire("key2", String("1234"), time.Now().Add(-time.Second))
+	lru.Set("key3", String("1234"))
+
+	if n := lru.RemoveExpired(); n != 2 {
+		t.Fatalf("expect 2 expired entries removed, but %d got", n)
+	}
+	if lru.Len() != 1 || lru.Bytes() != int64(len("key3")+len("1234")) {
+		t.Fatalf("expect only key3 left, but %d entries %d bytes got", lru.Len(), lru.Bytes())
+	}
+}
+
+func TestStats(t *testing.T) {
+	lru := NewLRUCache(int64(len("key1")+len("1234")), nil)
+	lru.Set("key1", String("1234"))
+	lru.Get("key1")
+	lru.Get("key2")
+	lru.Set("key2", String("1234"))
+	lru.SetWithExpire("key2", String("1234"), time.Now().Add(-time.Second))
+	lru.Get("key2")
+
+	expect := Stats{Gets: 3, Hits: 1, Evictions: 1, Expirations: 1}
+	if stats := lru.Stats(); stats != expect {
+		t.Fatalf("expect %+v, but %+v got", expect, stats)
+	}
+}
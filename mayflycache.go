@@ -1,29 +1,144 @@
-package main
+// Package mayflycache provides a distributed caching and cache-filling library.
+//
+// A Group loads values through a Getter on a cache miss and keeps them in
+// its mainCache; registered peers (e.g. an HTTPPool) share the key space
+// between nodes according to consistent hashing.
+package mayflycache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/hey-kong/mayflycache/eviction"
 	pb "github.com/hey-kong/mayflycache/mayflycachepb"
 )
 
+// A Getter loads data for a key, it is called when the key is not cached.
+// The ctx is the one passed to Group.Get, the loading should be abandoned
+// when it is done.
 type Getter interface {
-	Get(key string) ([]byte, error)
+	Get(ctx context.Context, key string) ([]byte, error)
 }
 
-type GetterFunc func(key string) ([]byte, error)
+// A GetterFunc implements Getter with a function.
+type GetterFunc func(ctx context.Context, key string) ([]byte, error)
 
-func (f GetterFunc) Get(key string) ([]byte, error) {
-	return f(key)
+// Get implements Getter interface function.
+func (f GetterFunc) Get(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
 }
 
+// An ExpirationGetter is a Getter that also decides when the loaded
+// data expires, the zero time means using the default TTL of the Group.
+type ExpirationGetter interface {
+	Getter
+	GetWithExpire(ctx context.Context, key string) ([]byte, time.Time, error)
+}
+
+// A Group is a cache namespace, its data is loaded by the Getter
+// and spread over the peers.
 type Group struct {
+	stats     groupStats
+	metrics   *groupMetrics
 	name      string
-	mainCache *SafeCache
+	mainCache cache
+	newPolicy eviction.NewFunc // eviction policy of the mainCache and hotCache
+	shards    int              // number of shards of the mainCache and hotCache, 1 means not sharded
 	getter    Getter
 	peers     PeerPicker
 	once      Once
+	detours   Once          // deduplicates the detours apart from the loads, which may wait for the owner they route around
+	ttl       time.Duration // default TTL of the loaded data, 0 means never expires
+	janitor   time.Duration // interval of removing the expired entries, 0 means no janitor
+
+	// hotCache contains keys/values for which this peer is not
+	// authoritative, but are popular enough to have a copy of them
+	// locally to avoid the round trip to the owner, it is nil if
+	// it is not enabled.
+	hotCache   cache
+	hotEnabled bool    // whether to create the hotCache in NewGroup
+	hotBytes   int64   // maximum bytes of the hotCache
+	hotRatio   float64 // probability of populating the hotCache with a peer response
+
+	// negCache contains the keys reported not found by the Getter,
+	// it is nil if it is not enabled.
+	negCache cache
+	negBytes int64         // maximum bytes of the negCache
+	negTTL   time.Duration // TTL of the entries of the negCache, 0 means no negCache
+
+	filter atomic.Value // *bloom.Filter of the keys known to exist, nil means no guard
+
+	maxStale     time.Duration   // how long the expired values are served stale, 0 means not served
+	refreshAhead time.Duration   // window before the expiration to refresh the accessed values, 0 means no refresh-ahead
+	refreshMu    sync.Mutex      // guards refreshing
+	refreshing   map[string]bool // keys being refreshed in background
+
+	hedgeDelay time.Duration // delay of the duplicate request to a slow owner, 0 means no hedging
+	retries    int           // maximum retries of a failed Get from a peer
+	backoff    time.Duration // backoff before the first retry, doubled after each one
+
+	setInvalidation bool // whether Set invalidates the copies on other peers
+}
+
+// A GroupOption configures the Group in NewGroup.
+type GroupOption func(*Group)
+
+// WithTTL sets the default TTL of the data loaded by the Getter.
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.ttl = ttl
+	}
+}
+
+// WithJanitor makes the Group remove the expired entries every interval
+// in background, otherwise they are only purged when accessed or evicted.
+func WithJanitor(interval time.Duration) GroupOption {
+	return func(g *Group) {
+		g.janitor = interval
+	}
+}
+
+// WithHotCache enables the hotCache holding at most maxBytes, the values
+// loaded from peers are copied into it with the probability ratio, while
+// the ones loaded from the peers picked instead of the owner are all
+// copied.
+func WithHotCache(maxBytes int64, ratio float64) GroupOption {
+	return func(g *Group) {
+		g.hotEnabled = true
+		g.hotBytes = maxBytes
+		g.hotRatio = ratio
+	}
+}
+
+// WithEvictionPolicy makes the caches of the Group evict the entries by
+// the policy created with newPolicy, e.g. eviction.LFU, instead of LRU.
+func WithEvictionPolicy(newPolicy eviction.NewFunc) GroupOption {
+	return func(g *Group) {
+		g.newPolicy = newPolicy
+	}
+}
+
+// WithShards splits the caches of the Group into n shards locked
+// independently, each holds an equal part of the bytes, so the
+// concurrent requests for different keys don't wait for one lock.
+func WithShards(n int) GroupOption {
+	return func(g *Group) {
+		g.shards = n
+	}
+}
+
+// WithSetInvalidation makes Set broadcast the invalidation of the key to
+// all the peers, so their hot copies are dropped.
+func WithSetInvalidation() GroupOption {
+	return func(g *Group) {
+		g.setInvalidation = true
+	}
 }
 
 var (
@@ -33,7 +148,7 @@ var (
 
 // NewGroup initializes all fields except PeerPicker,
 // which needs to call RegisterPeers.
-func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+func NewGroup(name string, cacheBytes int64, getter Getter, opts ...GroupOption) *Group {
 	if getter == nil {
 		panic("Nil Getter")
 	}
@@ -43,13 +158,42 @@ func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
 
 	g := &Group{
 		name:      name,
-		mainCache: &SafeCache{maxBytes: cacheBytes},
+		newPolicy: eviction.LRU,
+		shards:    1,
 		getter:    getter,
+		metrics:   newGroupMetrics(),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	g.mainCache = g.newCache(cacheBytes)
+	if g.hotEnabled {
+		g.hotCache = g.newCache(g.hotBytes)
+	}
+	if g.negTTL > 0 {
+		g.negCache = g.newCache(g.negBytes)
+	}
+	if g.janitor > 0 {
+		g.mainCache.StartJanitor(g.janitor)
+		if g.hotCache != nil {
+			g.hotCache.StartJanitor(g.janitor)
+		}
+		if g.negCache != nil {
+			g.negCache.StartJanitor(g.janitor)
+		}
 	}
 	groups[name] = g
 	return g
 }
 
+// newCache returns the cache holding at most maxBytes by the options.
+func (g *Group) newCache(maxBytes int64) cache {
+	if g.shards > 1 {
+		return NewShardedCache(maxBytes, g.shards, g.newPolicy)
+	}
+	return NewSafeCacheWithPolicy(maxBytes, g.newPolicy)
+}
+
 // RegisterPeers registers PeerPicker of the Group.
 func (g *Group) RegisterPeers(peers PeerPicker) {
 	if g.peers != nil {
@@ -66,36 +210,119 @@ func GetGroup(name string) *Group {
 	return g
 }
 
-// It tries to get the cached data from its mainCache;
+// Get returns the value of the key.
+// It tries to get the cached data from its mainCache and hotCache;
 // If not, call g.load to use Getter or get data from peer node.
-func (g *Group) Get(key string) (Chunk, error) {
+// The loading is abandoned when ctx is done.
+func (g *Group) Get(ctx context.Context, key string) (Chunk, error) {
+	return g.get(ctx, key, true)
+}
+
+// getDetour is Get of a key that a peer has sent to the current node
+// instead of its owner, e.g. because the owner is slow or overloaded, so
+// it is loaded by the Getter rather than sent back to the owner.
+func (g *Group) getDetour(ctx context.Context, key string) (Chunk, error) {
+	return g.get(ctx, key, false)
+}
+
+// get returns the value of the key, which is loaded from the peers
+// only if forward is true.
+func (g *Group) get(ctx context.Context, key string, forward bool) (Chunk, error) {
 	// Null key is handled here to prevent cache penetration
 	if key == "" {
 		return Chunk{}, fmt.Errorf("key is required")
 	}
+	g.stats.gets.Add(1)
 	// Try to get a cached chunk, and return it if you get it
-	if v, ok := g.mainCache.Get(key); ok {
-		log.Println("Cache Hit")
+	if v, ok := g.lookupCache(key); ok {
 		return v, nil
 	}
+	if g.lookupNegative(key) || g.rejectByFilter(key) {
+		return Chunk{}, ErrNotFound
+	}
 	// Otherwise, load the data into the cache
-	return g.load(key)
+	g.stats.misses.Add(1)
+	if !forward {
+		return g.loadDetour(ctx, key)
+	}
+	return g.load(ctx, key)
+}
+
+// lookupCache returns the value of the key from the mainCache or the hotCache.
+func (g *Group) lookupCache(key string) (Chunk, bool) {
+	if v, ok := g.mainCache.Get(key); ok {
+		log.Println("Cache Hit")
+		g.stats.hits.Add(1)
+		g.revalidate(key, v)
+		return v, true
+	}
+	if g.hotCache != nil {
+		if v, ok := g.hotCache.Get(key); ok {
+			log.Println("Hot Cache Hit")
+			g.stats.hits.Add(1)
+			return v, true
+		}
+	}
+	return Chunk{}, false
 }
 
 // If its peers is nil，call getLocally to get;
-// Else call peers.PickPeer to get peer node, and call getFromPeer to get data from remote.
-func (g *Group) load(key string) (value Chunk, err error) {
-	tmpValue, err := g.once.Do(key, func() (interface{}, error) {
-		if g.peers != nil {
-			if peer, ok := g.peers.PickPeer(key); ok {
-				if value, err = g.getFromPeer(peer, key); err == nil {
+// Else call g.pickPeer to get peer node, and call getFromPeer to get data from remote.
+func (g *Group) load(ctx context.Context, key string) (value Chunk, err error) {
+	executed := false
+	tmpValue, err := g.once.Do(ctx, key, func() (interface{}, error) {
+		executed = true
+		r := g.pickPeer(key)
+		if r.peer != nil {
+			var local bool
+			if value, local, err = g.fetch(ctx, r.peer, key, r.detour); err == nil {
+				// The hedge has loaded it by the Getter
+				if local {
 					return value, nil
 				}
-				log.Println("Failed to get from peer:", err)
+				g.stats.peerLoads.Add(1)
+				// A replica keeps the value like the owner, while only a part
+				// of the popular keys are copied locally by the others. The
+				// detoured values are all kept, since the peer serving them
+				// doesn't keep them in its mainCache.
+				if r.replica {
+					g.populateCache(key, value)
+				} else if g.hotCache != nil && (r.detour || rand.Float64() < g.hotRatio) {
+					g.hotCache.Set(key, value)
+				}
+				return value, nil
+			}
+			// The hedge has failed to load it by the Getter as well
+			if local {
+				return nil, err
+			}
+			// The owner has loaded the key successfully, it just doesn't exist
+			if errors.Is(err, ErrNotFound) {
+				g.cacheNegative(key, err)
+				return nil, err
+			}
+			g.stats.peerErrors.Add(1)
+			// Don't fall back to the Getter if the caller has given up
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			log.Println("Failed to get from peer:", err)
+		} else if !r.owner {
+			// The current node is picked instead of the owner
+			return g.getLocallyHot(ctx, key)
+		}
+		value, err = g.getLocally(ctx, key)
+		// Only the owner pushes the value to the replicas
+		if err == nil && r.owner {
+			if peers, _ := g.pickReplicas(key); len(peers) > 0 {
+				go g.replicate(context.Background(), key, value, peers, nil)
 			}
 		}
-		return g.getLocally(key)
+		return value, err
 	})
+	if !executed {
+		g.stats.dedups.Add(1)
+	}
 
 	if err == nil {
 		return tmpValue.(Chunk), nil
@@ -103,31 +330,284 @@ func (g *Group) load(key string) (value Chunk, err error) {
 	return
 }
 
-func (g *Group) getFromPeer(peer PeerGetter, key string) (Chunk, error) {
+// loadDetour loads the key by the Getter, deduplicated with the other detours of the key.
+func (g *Group) loadDetour(ctx context.Context, key string) (Chunk, error) {
+	executed := false
+	value, err := g.detours.Do(ctx, key, func() (interface{}, error) {
+		executed = true
+		return g.getLocallyHot(ctx, key)
+	})
+	if !executed {
+		g.stats.dedups.Add(1)
+	}
+	if err != nil {
+		return Chunk{}, err
+	}
+	return value.(Chunk), nil
+}
+
+func (g *Group) getFromPeer(ctx context.Context, peer PeerGetter, key string) (Chunk, error) {
 	req := &pb.Request{
 		Group: g.name,
 		Key:   key,
 	}
 	res := &pb.Response{}
-	err := peer.Get(req, res)
+	start := time.Now()
+	err := peer.Get(ctx, req, res)
+	g.metrics.observePeer(peerName(peer), time.Since(start), err)
 	if err != nil {
 		return Chunk{}, err
 	}
-	return NewChunk(res.Value), nil
+	if res.NotFound {
+		return Chunk{}, ErrNotFound
+	}
+	var expire time.Time
+	if res.Expire != 0 {
+		expire = time.Unix(0, res.Expire)
+	}
+	return NewChunkWithExpire(res.Value, expire), nil
 }
 
-func (g *Group) getLocally(key string) (value Chunk, err error) {
-	// Call getter to get data
-	bytes, err := g.getter.Get(key)
+func (g *Group) getLocally(ctx context.Context, key string) (Chunk, error) {
+	value, err := g.getFromGetter(ctx, key)
 	if err != nil {
-		return
+		return Chunk{}, err
 	}
-	// Save the data to the chunk and cache it
-	value = NewChunk(bytes)
 	g.populateCache(key, value)
 	return value, nil
 }
 
+// getLocallyHot loads the key by the Getter like getLocally, but keeps the
+// value in the hotCache only, as the current node doesn't own the key.
+func (g *Group) getLocallyHot(ctx context.Context, key string) (Chunk, error) {
+	value, err := g.getFromGetter(ctx, key)
+	if err != nil {
+		return Chunk{}, err
+	}
+	if g.hotCache != nil {
+		g.hotCache.Set(key, value)
+	}
+	return value, nil
+}
+
+// getFromGetter loads the value of the key by the Getter without caching it.
+func (g *Group) getFromGetter(ctx context.Context, key string) (value Chunk, err error) {
+	// Call getter to get data
+	var bytes []byte
+	var expire time.Time
+	start := time.Now()
+	if eg, ok := g.getter.(ExpirationGetter); ok {
+		bytes, expire, err = eg.GetWithExpire(ctx, key)
+	} else {
+		bytes, err = g.getter.Get(ctx, key)
+	}
+	g.metrics.loadLatency.observe(time.Since(start))
+	if err != nil {
+		g.stats.localLoadErrs.Add(1)
+		g.cacheNegative(key, err)
+		return
+	}
+	g.stats.localLoads.Add(1)
+	return NewChunkWithExpire(bytes, g.expireOf(expire)), nil
+}
+
+// expireOf returns the expiration decided by the Getter,
+// or the one by the default TTL if it is zero.
+func (g *Group) expireOf(expire time.Time) time.Time {
+	if expire.IsZero() && g.ttl > 0 {
+		return time.Now().Add(g.ttl)
+	}
+	return expire
+}
+
 func (g *Group) populateCache(key string, value Chunk) {
-	g.mainCache.Set(key, value)
+	g.mainCache.Set(key, g.keepStale(value))
+}
+
+// Name returns the name of the group.
+func (g *Group) Name() string {
+	return g.name
+}
+
+// Stats returns a snapshot of the statistics of the group.
+func (g *Group) Stats() Stats {
+	s := Stats{
+		Gets:            g.stats.gets.Get(),
+		Hits:            g.stats.hits.Get(),
+		Misses:          g.stats.misses.Get(),
+		PeerLoads:       g.stats.peerLoads.Get(),
+		PeerErrors:      g.stats.peerErrors.Get(),
+		LocalLoads:      g.stats.localLoads.Get(),
+		LocalLoadErrs:   g.stats.localLoadErrs.Get(),
+		ReplicaPushes:   g.stats.replicaPushes.Get(),
+		ReplicaPushErrs: g.stats.replicaPushErrs.Get(),
+		Dedups:          g.stats.dedups.Get(),
+		NegativeHits:    g.stats.negativeHits.Get(),
+		FilterRejects:   g.stats.filterRejects.Get(),
+		StaleHits:       g.stats.staleHits.Get(),
+		Refreshes:       g.stats.refreshes.Get(),
+		Retries:         g.stats.retries.Get(),
+		Hedges:          g.stats.hedges.Get(),
+		HedgeWins:       g.stats.hedgeWins.Get(),
+		MainCache:       g.mainCache.Stats(),
+	}
+	if g.hotCache != nil {
+		s.HotCache = g.hotCache.Stats()
+	}
+	if g.negCache != nil {
+		s.NegativeCache = g.negCache.Stats()
+	}
+	s.Evictions = s.MainCache.Evictions + s.HotCache.Evictions
+	return s
+}
+
+// Set stores the value of the key in the mainCache of its owner, the value
+// expires after ttl, 0 means using the default TTL of the Group.
+// If the Group is created WithSetInvalidation, the copies of the key on
+// the other peers are invalidated too. If the Group is guarded WithFilter,
+// the key is only added to the filters of the current node, the owner and
+// the replicas, so the filter must be pushed to the other nodes again.
+func (g *Group) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+
+	if ttl == 0 {
+		ttl = g.ttl
+	}
+	var expire time.Time
+	if ttl > 0 {
+		expire = time.Now().Add(ttl)
+	}
+
+	var owner PeerGetter
+	if g.peers != nil {
+		if peer, ok := g.pickOwner(key); ok {
+			if err := g.setToPeer(ctx, peer, key, value, expire); err != nil {
+				return err
+			}
+			owner = peer
+		}
+	}
+	peers, replica := g.pickReplicas(key)
+	if owner == nil || replica {
+		g.setLocally(key, NewChunkWithExpire(value, expire))
+	} else {
+		// The local hot copy is stale now
+		if g.hotCache != nil {
+			g.hotCache.Delete(key)
+		}
+		g.dropNegative(key)
+		g.addToFilter(key)
+	}
+
+	if g.setInvalidation {
+		if err := g.invalidatePeers(ctx, key, owner); err != nil {
+			return err
+		}
+	}
+	// The replicas are updated after the invalidation, which drops their copies too
+	if len(peers) > 0 {
+		g.replicate(ctx, key, NewChunkWithExpire(value, expire), peers, owner)
+	}
+	return nil
+}
+
+// pickOwner returns the owner of the key to write to, ok is false if the
+// current node owns the key. The PeerPicker that isn't an OwnerPicker is
+// assumed to pick the owner.
+func (g *Group) pickOwner(key string) (PeerGetter, bool) {
+	if op, ok := g.peers.(OwnerPicker); ok {
+		return op.PickOwner(key)
+	}
+	return g.peers.PickPeer(key)
+}
+
+func (g *Group) setToPeer(ctx context.Context, peer PeerGetter, key string, value []byte, expire time.Time) error {
+	req := &pb.SetRequest{
+		Group: g.name,
+		Key:   key,
+		Value: value,
+	}
+	if !expire.IsZero() {
+		req.Expire = expire.UnixNano()
+	}
+	return peer.Set(ctx, req)
+}
+
+// setLocally stores the value of the key that the current node owns.
+func (g *Group) setLocally(key string, value Chunk) {
+	g.populateCache(key, value)
+	if g.hotCache != nil {
+		g.hotCache.Delete(key)
+	}
+	g.dropNegative(key)
+	g.addToFilter(key)
+}
+
+// Remove deletes the key from the owner first, then invalidates
+// the copies of it on the current node and all the other peers.
+func (g *Group) Remove(ctx context.Context, key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+
+	var owner PeerGetter
+	if g.peers != nil {
+		if peer, ok := g.pickOwner(key); ok {
+			if err := g.removeFromPeer(ctx, peer, key); err != nil {
+				return err
+			}
+			owner = peer
+		}
+	}
+	g.removeLocally(key)
+	return g.invalidatePeers(ctx, key, owner)
+}
+
+// invalidatePeers broadcasts the invalidation of the key to drop
+// the copies on all the peers except the owner.
+func (g *Group) invalidatePeers(ctx context.Context, key string, owner PeerGetter) error {
+	if g.peers == nil {
+		return nil
+	}
+
+	peers := g.peers.GetAll()
+	var wg sync.WaitGroup
+	errs := make(chan error, len(peers))
+	for _, peer := range peers {
+		if peer == owner {
+			continue
+		}
+		wg.Add(1)
+		go func(peer PeerGetter) {
+			defer wg.Done()
+			errs <- g.removeFromPeer(ctx, peer, key)
+		}(peer)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Group) removeFromPeer(ctx context.Context, peer PeerGetter, key string) error {
+	req := &pb.Request{
+		Group: g.name,
+		Key:   key,
+	}
+	return peer.Delete(ctx, req)
+}
+
+func (g *Group) removeLocally(key string) {
+	g.mainCache.Delete(key)
+	if g.hotCache != nil {
+		g.hotCache.Delete(key)
+	}
+	g.dropNegative(key)
 }
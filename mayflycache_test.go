@@ -1,9 +1,17 @@
-package main
+package mayflycache_test
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/hey-kong/mayflycache"
+	"github.com/hey-kong/mayflycache/eviction"
+	pb "github.com/hey-kong/mayflycache/mayflycachepb"
 )
 
 var info = map[string]string{
@@ -14,8 +22,8 @@ var info = map[string]string{
 
 func TestGroup(t *testing.T) {
 	queryCount := make(map[string]int)
-	g := NewGroup("info", 2<<10, GetterFunc(
-		func(key string) ([]byte, error) {
+	g := mayflycache.NewGroup("info", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
 			log.Println("Load", key, "from database")
 			if value, ok := info[key]; ok {
 				queryCount[key] += 1
@@ -27,16 +35,548 @@ func TestGroup(t *testing.T) {
 	))
 
 	for k, v := range info {
-		if value, err := g.Get(k); err != nil || value.String() != v {
+		if value, err := g.Get(context.Background(), k); err != nil || value.String() != v {
 			log.Fatal("First get test failed")
 		}
 
-		if _, err := g.Get(k); err != nil || queryCount[k] > 1 {
+		if _, err := g.Get(context.Background(), k); err != nil || queryCount[k] > 1 {
 			log.Fatal("Second get test failed")
 		}
 	}
 
-	if _, err := g.Get("Unknown"); err == nil {
+	if _, err := g.Get(context.Background(), "Unknown"); err == nil {
 		log.Fatal("Third get test failed")
 	}
 }
+
+func TestGetGroup(t *testing.T) {
+	g := mayflycache.NewGroup("scores", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) { return []byte(key), nil },
+	))
+	if mayflycache.GetGroup("scores") != g {
+		t.Fatalf("group scores not exist")
+	}
+	if mayflycache.GetGroup("scores"+"111") != nil {
+		t.Fatalf("expect nil, but %s got", "scores111")
+	}
+}
+
+func TestChunk(t *testing.T) {
+	b := []byte("value")
+	c := mayflycache.NewChunk(b)
+	b[0] = 'V'
+	if c.String() != "value" || c.Size() != 5 {
+		t.Fatalf("chunk should hold a copy of the byte slice, got %q", c.String())
+	}
+	c.ByteSlice()[0] = 'V'
+	if c.String() != "value" {
+		t.Fatalf("ByteSlice should return a copy, got %q", c.String())
+	}
+}
+
+func TestHTTPPool(t *testing.T) {
+	mayflycache.NewGroup("remote", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) { return []byte("remote-" + key), nil },
+	))
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config.Handler = mayflycache.NewHTTPPool("http://" + ts.Listener.Addr().String())
+	ts.Start()
+	defer ts.Close()
+
+	// A second pool that only knows about the server above
+	// picks it as the owner of every key.
+	client := mayflycache.NewHTTPPool("http://client")
+	client.Set(ts.URL)
+	peer, ok := client.PickPeer("Name")
+	if !ok {
+		t.Fatalf("expect to pick the peer %s", ts.URL)
+	}
+
+	res := &pb.Response{}
+	if err := peer.Get(context.Background(), &pb.Request{Group: "remote", Key: "Name"}, res); err != nil {
+		t.Fatalf("get from peer failed: %v", err)
+	}
+	if string(res.GetValue()) != "remote-Name" {
+		t.Fatalf("expect remote-Name, but %s got", res.GetValue())
+	}
+
+	if err := peer.Get(context.Background(), &pb.Request{Group: "unknown", Key: "Name"}, res); err == nil {
+		t.Fatalf("expect error for unknown group")
+	}
+}
+
+func TestOnce(t *testing.T) {
+	var o mayflycache.Once
+	v, err := o.Do(context.Background(), "key", func() (interface{}, error) {
+		return "bar", nil
+	})
+	if v != "bar" || err != nil {
+		t.Errorf("Do v = %v, error = %v", v, err)
+	}
+}
+
+func TestGroupTTL(t *testing.T) {
+	loads := 0
+	g := mayflycache.NewGroup("ttl", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			loads++
+			return []byte(key), nil
+		},
+	), mayflycache.WithTTL(20*time.Millisecond))
+
+	if v, err := g.Get(context.Background(), "Name"); err != nil || v.Expire().IsZero() {
+		t.Fatalf("expect the value to expire, but %v got", v.Expire())
+	}
+	if g.Get(context.Background(), "Name"); loads != 1 {
+		t.Fatalf("expect 1 load before expiration, but %d got", loads)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if g.Get(context.Background(), "Name"); loads != 2 {
+		t.Fatalf("expect the expired key to be loaded again, but %d loads got", loads)
+	}
+}
+
+type expirationGetter map[string]time.Duration
+
+func (eg expirationGetter) Get(ctx context.Context, key string) ([]byte, error) {
+	return []byte(key), nil
+}
+
+func (eg expirationGetter) GetWithExpire(ctx context.Context, key string) ([]byte, time.Time, error) {
+	if d := eg[key]; d != 0 {
+		return []byte(key), time.Now().Add(d), nil
+	}
+	return []byte(key), time.Time{}, nil
+}
+
+func TestGroupExpirationGetter(t *testing.T) {
+	g := mayflycache.NewGroup("expiration", 2<<10, expirationGetter{"short": time.Minute},
+		mayflycache.WithTTL(time.Hour))
+
+	short, _ := g.Get(context.Background(), "short")
+	if d := time.Until(short.Expire()); d > time.Minute || d <= 0 {
+		t.Fatalf("expect the key short to expire in a minute, but %v got", d)
+	}
+	other, _ := g.Get(context.Background(), "other")
+	if d := time.Until(other.Expire()); d <= time.Minute {
+		t.Fatalf("expect the key other to use the default TTL, but %v got", d)
+	}
+}
+
+func TestSafeCacheJanitor(t *testing.T) {
+	c := mayflycache.NewSafeCache(0)
+	c.Set("key1", mayflycache.NewChunkWithExpire([]byte("1"), time.Now().Add(10*time.Millisecond)))
+	c.Set("key2", mayflycache.NewChunk([]byte("2")))
+	if n := c.RemoveExpired(); n != 0 {
+		t.Fatalf("expect nothing removed, but %d got", n)
+	}
+
+	c.StartJanitor(5 * time.Millisecond)
+	defer c.StopJanitor()
+	time.Sleep(30 * time.Millisecond)
+	// The janitor reclaims the bytes of key1 without accessing it
+	if b := c.Bytes(); b != int64(len("key2")+1) {
+		t.Fatalf("expect %d bytes after expiration, but %d got", len("key2")+1, b)
+	}
+	if _, ok := c.Get("key2"); !ok {
+		t.Fatalf("key2 should not be expired")
+	}
+}
+
+func TestOnceWaiterAbandon(t *testing.T) {
+	var o mayflycache.Once
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go o.Do(context.Background(), "key", func() (interface{}, error) {
+		close(started)
+		<-release
+		return "bar", nil
+	})
+	<-started
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := o.Do(ctx, "key", func() (interface{}, error) {
+		return "baz", nil
+	}); err != context.DeadlineExceeded {
+		t.Fatalf("expect the waiter to abandon with %v, but %v got", context.DeadlineExceeded, err)
+	}
+}
+
+func TestOnceLeaderAbandon(t *testing.T) {
+	var o mayflycache.Once
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	go o.Do(leaderCtx, "key", func() (interface{}, error) {
+		close(started)
+		<-leaderCtx.Done()
+		return nil, leaderCtx.Err()
+	})
+	<-started
+
+	done := make(chan struct{})
+	var v interface{}
+	var err error
+	go func() {
+		defer close(done)
+		v, err = o.Do(context.Background(), "key", func() (interface{}, error) {
+			return "bar", nil
+		})
+	}()
+	// Let the waiter join the shared call before the leader gives up
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	<-done
+	if v != "bar" || err != nil {
+		t.Fatalf("expect the waiter to call again, but %v, %v got", v, err)
+	}
+}
+
+func TestGetCanceledAcrossPeers(t *testing.T) {
+	canceled := make(chan struct{})
+	mayflycache.NewGroup("slow", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			<-ctx.Done()
+			close(canceled)
+			return nil, ctx.Err()
+		},
+	))
+	ts := httptest.NewServer(mayflycache.NewHTTPPool("http://server"))
+	defer ts.Close()
+
+	client := mayflycache.NewHTTPPool("http://client")
+	client.Set(ts.URL)
+	peer, _ := client.PickPeer("Name")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := peer.Get(ctx, &pb.Request{Group: "slow", Key: "Name"}, &pb.Response{}); err == nil {
+		t.Fatalf("expect the peer request to fail after the deadline")
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatalf("the Getter on the peer is not canceled")
+	}
+}
+
+func TestGroupGetCanceled(t *testing.T) {
+	g := mayflycache.NewGroup("blocking", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.Get(ctx, "Name"); err != context.Canceled {
+		t.Fatalf("expect %v, but %v got", context.Canceled, err)
+	}
+}
+
+// fakePeer serves every key as its owner and counts the requests,
+// others are the peers not owning any key.
+type fakePeer struct {
+	mu      sync.Mutex
+	calls   int
+	deletes int
+	batches int
+	sets    map[string]*pb.SetRequest
+	others  []*fakePeer
+}
+
+func (p *fakePeer) PickPeer(key string) (mayflycache.PeerGetter, bool) {
+	return p, true
+}
+
+func (p *fakePeer) GetAll() []mayflycache.PeerGetter {
+	peers := []mayflycache.PeerGetter{p}
+	for _, other := range p.others {
+		peers = append(peers, other)
+	}
+	return peers
+}
+
+func (p *fakePeer) Set(ctx context.Context, in *pb.SetRequest) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sets == nil {
+		p.sets = make(map[string]*pb.SetRequest)
+	}
+	p.sets[in.GetKey()] = in
+	return nil
+}
+
+func (p *fakePeer) Delete(ctx context.Context, in *pb.Request) error {
+	p.mu.Lock()
+	p.deletes++
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *fakePeer) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	p.mu.Lock()
+	p.batches++
+	p.mu.Unlock()
+	for _, key := range in.GetKeys() {
+		out.Results = append(out.Results, &pb.BatchResult{Value: []byte("peer-" + key)})
+	}
+	return nil
+}
+
+func (p *fakePeer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	p.mu.Lock()
+	p.calls++
+	p.mu.Unlock()
+	out.Value = []byte("peer-" + in.GetKey())
+	return nil
+}
+
+func TestHotCache(t *testing.T) {
+	g := mayflycache.NewGroup("hot", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			t.Fatalf("the key %s should be loaded from the peer", key)
+			return nil, nil
+		},
+	), mayflycache.WithHotCache(int64(len("k1")+len("peer-k1")), 1))
+	peer := &fakePeer{}
+	g.RegisterPeers(peer)
+
+	for i := 0; i < 3; i++ {
+		if v, err := g.Get(context.Background(), "k1"); err != nil || v.String() != "peer-k1" {
+			t.Fatalf("expect peer-k1, but %v %v got", v, err)
+		}
+	}
+	if peer.calls != 1 {
+		t.Fatalf("expect the hot key to be fetched once, but %d calls got", peer.calls)
+	}
+
+	// The hotCache has its own byte budget, k1 is evicted by k2
+	g.Get(context.Background(), "k2")
+	g.Get(context.Background(), "k1")
+	if peer.calls != 3 {
+		t.Fatalf("expect k1 to be evicted from the hotCache, but %d calls got", peer.calls)
+	}
+}
+
+func TestHotCacheRatio(t *testing.T) {
+	g := mayflycache.NewGroup("cold", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) { return nil, nil },
+	), mayflycache.WithHotCache(2<<10, 0))
+	peer := &fakePeer{}
+	g.RegisterPeers(peer)
+
+	for i := 0; i < 3; i++ {
+		g.Get(context.Background(), "k1")
+	}
+	if peer.calls != 3 {
+		t.Fatalf("expect nothing copied into the hotCache, but %d calls got", peer.calls)
+	}
+}
+
+func TestSafeCacheEvictions(t *testing.T) {
+	c := mayflycache.NewSafeCache(int64(len("key1") + 1))
+	c.Set("key1", mayflycache.NewChunk([]byte("1")))
+	c.Set("key2", mayflycache.NewChunk([]byte("2")))
+	if s := c.Stats(); c.Len() != 1 || s.Items != 1 || s.Evictions != 1 {
+		t.Fatalf("expect 1 entry and 1 eviction, but %+v got", s)
+	}
+}
+
+func TestGroupEvictionPolicy(t *testing.T) {
+	loads := make(map[string]int)
+	g := mayflycache.NewGroup("lfu", int64(2*(len("key1")+1)), mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			loads[key]++
+			return []byte("1"), nil
+		},
+	), mayflycache.WithEvictionPolicy(eviction.LFU))
+
+	g.Get(context.Background(), "key1")
+	g.Get(context.Background(), "key1")
+	g.Get(context.Background(), "key2")
+	// key2 is used less than key1 and evicted by LFU, LRU would evict key1
+	g.Get(context.Background(), "key3")
+	g.Get(context.Background(), "key1")
+	if loads["key1"] != 1 {
+		t.Fatalf("expect key1 to stay cached, but %d loads got", loads["key1"])
+	}
+}
+
+func TestGroupRemove(t *testing.T) {
+	g := mayflycache.NewGroup("remove", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) { return nil, nil },
+	), mayflycache.WithHotCache(2<<10, 1))
+	other := &fakePeer{}
+	owner := &fakePeer{others: []*fakePeer{other}}
+	g.RegisterPeers(owner)
+
+	g.Get(context.Background(), "k1")
+	if err := g.Remove(context.Background(), "k1"); err != nil {
+		t.Fatalf("remove k1 failed: %v", err)
+	}
+	if owner.deletes != 1 || other.deletes != 1 {
+		t.Fatalf("expect 1 delete on each peer, but %d and %d got", owner.deletes, other.deletes)
+	}
+	// The hot copy is dropped, so k1 is fetched from the owner again
+	g.Get(context.Background(), "k1")
+	if owner.calls != 2 {
+		t.Fatalf("expect k1 to be fetched again, but %d calls got", owner.calls)
+	}
+}
+
+func TestHTTPPoolDelete(t *testing.T) {
+	loads := 0
+	g := mayflycache.NewGroup("delete", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			loads++
+			return []byte(key), nil
+		},
+	))
+	ts := httptest.NewServer(mayflycache.NewHTTPPool("http://server"))
+	defer ts.Close()
+
+	client := mayflycache.NewHTTPPool("http://client")
+	client.Set(ts.URL)
+	peer, _ := client.PickPeer("Name")
+	if peers := client.GetAll(); len(peers) != 1 || peers[0] != peer {
+		t.Fatalf("expect GetAll to return the only peer, but %v got", peers)
+	}
+
+	g.Get(context.Background(), "Name")
+	if err := peer.Delete(context.Background(), &pb.Request{Group: "delete", Key: "Name"}); err != nil {
+		t.Fatalf("delete from peer failed: %v", err)
+	}
+	if g.Get(context.Background(), "Name"); loads != 2 {
+		t.Fatalf("expect the deleted key to be loaded again, but %d loads got", loads)
+	}
+}
+
+func TestGroupSet(t *testing.T) {
+	g := mayflycache.NewGroup("set", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			t.Fatalf("the key %s should not be loaded", key)
+			return nil, nil
+		},
+	), mayflycache.WithTTL(time.Hour))
+
+	if err := g.Set(context.Background(), "k1", []byte("v1"), time.Minute); err != nil {
+		t.Fatalf("set k1 failed: %v", err)
+	}
+	v, err := g.Get(context.Background(), "k1")
+	if err != nil || v.String() != "v1" {
+		t.Fatalf("expect v1, but %v %v got", v, err)
+	}
+	if d := time.Until(v.Expire()); d > time.Minute || d <= 0 {
+		t.Fatalf("expect k1 to expire in a minute, but %v got", d)
+	}
+
+	g.Set(context.Background(), "k2", []byte("v2"), 0)
+	if v, _ := g.Get(context.Background(), "k2"); time.Until(v.Expire()) <= time.Minute {
+		t.Fatalf("expect k2 to use the default TTL, but %v got", v.Expire())
+	}
+}
+
+func TestGroupSetToPeer(t *testing.T) {
+	g := mayflycache.NewGroup("setpeer", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) { return nil, nil },
+	), mayflycache.WithSetInvalidation())
+	other := &fakePeer{}
+	owner := &fakePeer{others: []*fakePeer{other}}
+	g.RegisterPeers(owner)
+
+	if err := g.Set(context.Background(), "k1", []byte("v1"), 0); err != nil {
+		t.Fatalf("set k1 failed: %v", err)
+	}
+	if req := owner.sets["k1"]; req == nil || string(req.GetValue()) != "v1" || req.GetExpire() != 0 {
+		t.Fatalf("expect k1=v1 stored on the owner, but %v got", req)
+	}
+	if owner.deletes != 0 || other.deletes != 1 {
+		t.Fatalf("expect only the other peer invalidated, but %d and %d got", owner.deletes, other.deletes)
+	}
+}
+
+func TestHTTPPoolSet(t *testing.T) {
+	g := mayflycache.NewGroup("put", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			return []byte("origin"), nil
+		},
+	))
+	ts := httptest.NewServer(mayflycache.NewHTTPPool("http://server"))
+	defer ts.Close()
+
+	client := mayflycache.NewHTTPPool("http://client")
+	client.Set(ts.URL)
+	peer, _ := client.PickPeer("Name")
+
+	expire := time.Now().Add(time.Hour).Round(0)
+	req := &pb.SetRequest{Group: "put", Key: "Name", Value: []byte("written"), Expire: expire.UnixNano()}
+	if err := peer.Set(context.Background(), req); err != nil {
+		t.Fatalf("set on peer failed: %v", err)
+	}
+	v, err := g.Get(context.Background(), "Name")
+	if err != nil || v.String() != "written" || !v.Expire().Equal(expire) {
+		t.Fatalf("expect the written value expiring at %v, but %v %v got", expire, v.Expire(), err)
+	}
+}
+
+func TestGroupStats(t *testing.T) {
+	release := make(chan struct{})
+	g := mayflycache.NewGroup("stats", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			if key == "Unknown" {
+				return nil, fmt.Errorf("%s not exists", key)
+			}
+			<-release
+			return []byte(key), nil
+		},
+	))
+
+	// Two concurrent loads of the same key share one Getter call
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Get(context.Background(), "Name")
+		}()
+	}
+	for g.Stats().Misses != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	// Give the second call time to wait for the first one
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	g.Get(context.Background(), "Name")
+	g.Get(context.Background(), "Unknown")
+
+	s := g.Stats()
+	if s.Gets != 4 || s.Hits != 1 || s.Misses != 3 || s.Dedups != 1 {
+		t.Fatalf("unexpected gets, hits, misses or dedups: %+v", s)
+	}
+	if s.LocalLoads != 1 || s.LocalLoadErrs != 1 || s.PeerLoads != 0 {
+		t.Fatalf("unexpected loads: %+v", s)
+	}
+	if s.MainCache.Items != 1 || s.MainCache.Bytes != int64(len("Name")*2) {
+		t.Fatalf("unexpected mainCache stats: %+v", s.MainCache)
+	}
+}
+
+func TestGroupPeerStats(t *testing.T) {
+	g := mayflycache.NewGroup("peerstats", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) { return []byte(key), nil },
+	), mayflycache.WithHotCache(2<<10, 1))
+	g.RegisterPeers(&fakePeer{})
+
+	g.Get(context.Background(), "k1")
+	g.Get(context.Background(), "k1")
+
+	s := g.Stats()
+	if s.PeerLoads != 1 || s.Hits != 1 || s.HotCache.Items != 1 || s.HotCache.Hits != 1 {
+		t.Fatalf("unexpected peer or hotCache stats: %+v", s)
+	}
+}
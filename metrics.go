@@ -0,0 +1,275 @@
+package mayflycache
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultBuckets are the upper bounds in seconds of the latency histograms,
+// the same as the default buckets of the Prometheus client.
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram counts the observations into cumulative buckets.
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64 // counts[i] is the number of observations <= defaultBuckets[i]
+	sum    float64
+	count  uint64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(defaultBuckets))}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range defaultBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// peerMetrics are the metrics of the requests to a peer.
+type peerMetrics struct {
+	latency *histogram
+	errors  AtomicInt
+}
+
+// groupMetrics are the metrics of a Group that are not
+// included in its Stats.
+type groupMetrics struct {
+	loadLatency *histogram // latency of the Getter
+
+	mu    sync.Mutex
+	peers map[string]*peerMetrics // keyed by the name of the peer
+}
+
+func newGroupMetrics() *groupMetrics {
+	return &groupMetrics{
+		loadLatency: newHistogram(),
+		peers:       make(map[string]*peerMetrics),
+	}
+}
+
+func (m *groupMetrics) observePeer(peer string, d time.Duration, err error) {
+	m.mu.Lock()
+	pm, ok := m.peers[peer]
+	if !ok {
+		pm = &peerMetrics{latency: newHistogram()}
+		m.peers[peer] = pm
+	}
+	m.mu.Unlock()
+
+	pm.latency.observe(d)
+	if err != nil {
+		pm.errors.Add(1)
+	}
+}
+
+// removePeer drops the metrics of the peer.
+func (m *groupMetrics) removePeer(peer string) {
+	m.mu.Lock()
+	delete(m.peers, peer)
+	m.mu.Unlock()
+}
+
+// forgetPeer drops the metrics of the peer from all the groups, it is
+// called when the peer is removed from a pool, so the metrics don't keep
+// the peers that have left the cluster.
+func forgetPeer(peer string) {
+	for _, g := range allGroups() {
+		g.metrics.removePeer(peer)
+	}
+}
+
+// peerName returns the label of the peer in the metrics.
+func peerName(peer PeerGetter) string {
+	if s, ok := peer.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", peer)
+}
+
+// MetricsHandler returns an http.Handler exporting the metrics of all
+// the groups in the Prometheus text exposition format, it is usually
+// served at "/metrics" alongside the HTTPPool.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		writeMetrics(bw, allGroups())
+		bw.Flush()
+	})
+}
+
+// allGroups returns the groups sorted by name.
+func allGroups() []*Group {
+	mu.RLock()
+	gs := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		gs = append(gs, g)
+	}
+	mu.RUnlock()
+
+	sort.Slice(gs, func(i, j int) bool {
+		return gs[i].name < gs[j].name
+	})
+	return gs
+}
+
+type counterDesc struct {
+	name, help string
+	value      func(s *Stats) int64
+}
+
+var groupCounters = []counterDesc{
+	{"mayflycache_gets_total", "Get requests, including from peers.", func(s *Stats) int64 { return s.Gets }},
+	{"mayflycache_hits_total", "Get requests served by the mainCache or the hotCache.", func(s *Stats) int64 { return s.Hits }},
+	{"mayflycache_misses_total", "Get requests that loaded the key.", func(s *Stats) int64 { return s.Misses }},
+	{"mayflycache_peer_loads_total", "Keys loaded from peers.", func(s *Stats) int64 { return s.PeerLoads }},
+	{"mayflycache_peer_errors_total", "Failed loads from peers.", func(s *Stats) int64 { return s.PeerErrors }},
+	{"mayflycache_local_loads_total", "Keys loaded by the Getter.", func(s *Stats) int64 { return s.LocalLoads }},
+	{"mayflycache_local_load_errors_total", "Failed loads by the Getter.", func(s *Stats) int64 { return s.LocalLoadErrs }},
+	{"mayflycache_replica_pushes_total", "Values pushed to the replicas.", func(s *Stats) int64 { return s.ReplicaPushes }},
+	{"mayflycache_replica_push_errors_total", "Failed pushes to the replicas.", func(s *Stats) int64 { return s.ReplicaPushErrs }},
+	{"mayflycache_dedups_total", "Loads that shared the result of a concurrent one.", func(s *Stats) int64 { return s.Dedups }},
+	{"mayflycache_negative_hits_total", "Get requests served as not found by the negative cache.", func(s *Stats) int64 { return s.NegativeHits }},
+	{"mayflycache_filter_rejects_total", "Get requests rejected as not found by the Bloom filter.", func(s *Stats) int64 { return s.FilterRejects }},
+	{"mayflycache_stale_hits_total", "Get requests served with an expired value while it is refreshed.", func(s *Stats) int64 { return s.StaleHits }},
+	{"mayflycache_refreshes_total", "Background refreshes of the stale or about to expire values.", func(s *Stats) int64 { return s.Refreshes }},
+	{"mayflycache_peer_retries_total", "Retried Get requests to peers.", func(s *Stats) int64 { return s.Retries }},
+	{"mayflycache_hedges_total", "Duplicate requests sent because the owner was slow.", func(s *Stats) int64 { return s.Hedges }},
+	{"mayflycache_hedge_wins_total", "Duplicate requests answered before the owner.", func(s *Stats) int64 { return s.HedgeWins }},
+}
+
+type cacheDesc struct {
+	name, help, typ string
+	value           func(s *CacheStats) int64
+}
+
+var cacheMetrics = []cacheDesc{
+	{"mayflycache_cache_bytes", "Bytes of memory in use by the cache.", "gauge", func(s *CacheStats) int64 { return s.Bytes }},
+	{"mayflycache_cache_items", "Entries in the cache.", "gauge", func(s *CacheStats) int64 { return s.Items }},
+	{"mayflycache_cache_evictions_total", "Entries evicted to free memory.", "counter", func(s *CacheStats) int64 { return s.Evictions }},
+	{"mayflycache_cache_expirations_total", "Expired entries removed.", "counter", func(s *CacheStats) int64 { return s.Expirations }},
+}
+
+func writeMetrics(w *bufio.Writer, gs []*Group) {
+	stats := make([]Stats, len(gs))
+	for i, g := range gs {
+		stats[i] = g.Stats()
+	}
+
+	for _, c := range groupCounters {
+		writeHeader(w, c.name, c.help, "counter")
+		for i, g := range gs {
+			writeSample(w, c.name, labels("group", g.name), float64(c.value(&stats[i])))
+		}
+	}
+
+	for _, c := range cacheMetrics {
+		writeHeader(w, c.name, c.help, c.typ)
+		for i, g := range gs {
+			writeSample(w, c.name, labels("group", g.name, "cache", "main"), float64(c.value(&stats[i].MainCache)))
+			if g.hotCache != nil {
+				writeSample(w, c.name, labels("group", g.name, "cache", "hot"), float64(c.value(&stats[i].HotCache)))
+			}
+			if g.negCache != nil {
+				writeSample(w, c.name, labels("group", g.name, "cache", "negative"), float64(c.value(&stats[i].NegativeCache)))
+			}
+		}
+	}
+
+	const loadName = "mayflycache_load_duration_seconds"
+	writeHeader(w, loadName, "Latency of the loads by the Getter.", "histogram")
+	for _, g := range gs {
+		writeHistogram(w, loadName, labels("group", g.name), g.metrics.loadLatency)
+	}
+
+	const peerLatencyName, peerErrName = "mayflycache_peer_request_duration_seconds", "mayflycache_peer_request_errors_total"
+	writeHeader(w, peerLatencyName, "Latency of the requests to peers.", "histogram")
+	for _, g := range gs {
+		for _, peer := range g.metrics.peerNames() {
+			writeHistogram(w, peerLatencyName, labels("group", g.name, "peer", peer), g.metrics.peer(peer).latency)
+		}
+	}
+	writeHeader(w, peerErrName, "Failed requests to peers.", "counter")
+	for _, g := range gs {
+		for _, peer := range g.metrics.peerNames() {
+			writeSample(w, peerErrName, labels("group", g.name, "peer", peer), float64(g.metrics.peer(peer).errors.Get()))
+		}
+	}
+}
+
+func (m *groupMetrics) peerNames() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.peers))
+	for name := range m.peers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *groupMetrics) peer(name string) *peerMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.peers[name]
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeSample(w *bufio.Writer, name, labels string, v float64) {
+	fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatFloat(v))
+}
+
+func writeHistogram(w *bufio.Writer, name, labels string, h *histogram) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	for i, bound := range defaultBuckets {
+		writeSample(w, name+"_bucket", labels+`,le="`+formatFloat(bound)+`"`, float64(counts[i]))
+	}
+	writeSample(w, name+"_bucket", labels+`,le="+Inf"`, float64(count))
+	writeSample(w, name+"_sum", labels, sum)
+	writeSample(w, name+"_count", labels, float64(count))
+}
+
+// labels formats the pairs of label names and values.
+func labels(kv ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(kv[i])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(kv[i+1]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,136 @@
+package mayflycache_test
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hey-kong/mayflycache"
+	pb "github.com/hey-kong/mayflycache/mayflycachepb"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	g := mayflycache.NewGroup("metrics", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			if key == "Unknown" {
+				return nil, fmt.Errorf("%s not exists", key)
+			}
+			return []byte(key), nil
+		},
+	), mayflycache.WithHotCache(2<<10, 1))
+	g.Get(context.Background(), "Name")
+	g.Get(context.Background(), "Name")
+	g.Get(context.Background(), "Unknown")
+
+	w := httptest.NewRecorder()
+	mayflycache.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE mayflycache_gets_total counter",
+		`mayflycache_gets_total{group="metrics"} 3`,
+		`mayflycache_hits_total{group="metrics"} 1`,
+		`mayflycache_misses_total{group="metrics"} 2`,
+		`mayflycache_local_loads_total{group="metrics"} 1`,
+		`mayflycache_local_load_errors_total{group="metrics"} 1`,
+		"# TYPE mayflycache_cache_bytes gauge",
+		`mayflycache_cache_bytes{group="metrics",cache="main"} 8`,
+		`mayflycache_cache_items{group="metrics",cache="hot"} 0`,
+		"# TYPE mayflycache_load_duration_seconds histogram",
+		`mayflycache_load_duration_seconds_bucket{group="metrics",le="+Inf"} 2`,
+		`mayflycache_load_duration_seconds_count{group="metrics"} 2`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expect the line %q in the metrics", line)
+		}
+	}
+}
+
+// namedPeer is a fakePeer with a name in the metrics that fails on demand.
+type namedPeer struct {
+	fakePeer
+	fail bool
+}
+
+func (p *namedPeer) PickPeer(key string) (mayflycache.PeerGetter, bool) {
+	return p, true
+}
+
+func (p *namedPeer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	if p.fail {
+		return fmt.Errorf("peer is down")
+	}
+	return p.fakePeer.Get(ctx, in, out)
+}
+
+func (p *namedPeer) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	if p.fail {
+		return fmt.Errorf("peer is down")
+	}
+	return p.fakePeer.GetMany(ctx, in, out)
+}
+
+func (p *namedPeer) String() string {
+	return "http://peer"
+}
+
+func TestPeerMetrics(t *testing.T) {
+	g := mayflycache.NewGroup("peermetrics", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) { return []byte(key), nil },
+	))
+	peer := &namedPeer{}
+	g.RegisterPeers(peer)
+
+	// The first request is served by the peer, the second one
+	// falls back to the Getter after the peer is down.
+	g.Get(context.Background(), "k1")
+	peer.fail = true
+	g.Get(context.Background(), "k2")
+
+	w := httptest.NewRecorder()
+	mayflycache.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		`mayflycache_peer_request_duration_seconds_count{group="peermetrics",peer="http://peer"} 2`,
+		`mayflycache_peer_request_errors_total{group="peermetrics",peer="http://peer"} 1`,
+		`mayflycache_peer_loads_total{group="peermetrics"} 1`,
+		`mayflycache_peer_errors_total{group="peermetrics"} 1`,
+		`mayflycache_local_loads_total{group="peermetrics"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expect the line %q in the metrics", line)
+		}
+	}
+}
+
+func TestPeerMetricsRemoved(t *testing.T) {
+	ts := slowServer(0, nil)
+	defer ts.Close()
+	hp := mayflycache.NewHTTPPool("http://client")
+	hp.Set(ts.URL)
+	g := mayflycache.NewGroup("peermetrics-removed", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) { return []byte(key), nil },
+	))
+	g.RegisterPeers(hp)
+	g.Get(context.Background(), "k1")
+
+	line := `mayflycache_peer_request_errors_total{group="peermetrics-removed",peer="` + ts.URL + `"} 0`
+	metrics := func() string {
+		w := httptest.NewRecorder()
+		mayflycache.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		return w.Body.String()
+	}
+	if !strings.Contains(metrics(), line+"\n") {
+		t.Fatalf("expect the line %q in the metrics", line)
+	}
+	// The peer that has left the cluster is not exported any more
+	hp.RemovePeers(ts.URL)
+	if strings.Contains(metrics(), ts.URL) {
+		t.Fatalf("expect the metrics of %s to be dropped", ts.URL)
+	}
+}
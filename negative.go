@@ -0,0 +1,48 @@
+package mayflycache
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by a Getter if the key doesn't exist, it may be
+// wrapped, e.g. fmt.Errorf("%s: %w", key, ErrNotFound). The Group caches
+// the result in the negative cache if it is enabled by WithNegativeCache,
+// so the requests for the missing key don't reach the Getter again.
+var ErrNotFound = errors.New("mayflycache: not found")
+
+// WithNegativeCache enables the negative cache holding at most maxBytes
+// of the keys reported not found by the Getter, each of them is cached
+// for ttl, which is usually shorter than the TTL of the values.
+func WithNegativeCache(maxBytes int64, ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.negBytes = maxBytes
+		g.negTTL = ttl
+	}
+}
+
+// lookupNegative reports whether the key is cached as not found.
+func (g *Group) lookupNegative(key string) bool {
+	if g.negCache == nil {
+		return false
+	}
+	if _, ok := g.negCache.Get(key); ok {
+		g.stats.negativeHits.Add(1)
+		return true
+	}
+	return false
+}
+
+// cacheNegative caches the key as not found if err is ErrNotFound.
+func (g *Group) cacheNegative(key string, err error) {
+	if g.negCache != nil && errors.Is(err, ErrNotFound) {
+		g.negCache.Set(key, NewChunkWithExpire(nil, time.Now().Add(g.negTTL)))
+	}
+}
+
+// dropNegative removes the key from the negative cache after it is set or removed.
+func (g *Group) dropNegative(key string) {
+	if g.negCache != nil {
+		g.negCache.Delete(key)
+	}
+}
@@ -0,0 +1,122 @@
+package mayflycache_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hey-kong/mayflycache"
+	pb "github.com/hey-kong/mayflycache/mayflycachepb"
+)
+
+func TestNegativeCache(t *testing.T) {
+	loads := 0
+	g := mayflycache.NewGroup("negative", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			loads++
+			if key == "Broken" {
+				return nil, errors.New("database is down")
+			}
+			return nil, fmt.Errorf("%s: %w", key, mayflycache.ErrNotFound)
+		},
+	), mayflycache.WithNegativeCache(1<<10, 20*time.Millisecond))
+
+	for i := 0; i < 3; i++ {
+		if _, err := g.Get(context.Background(), "Unknown"); !errors.Is(err, mayflycache.ErrNotFound) {
+			t.Fatalf("expect ErrNotFound, but %v got", err)
+		}
+	}
+	if s := g.Stats(); loads != 1 || s.NegativeHits != 2 || s.NegativeCache.Items != 1 {
+		t.Fatalf("expect 1 load and 2 negative hits, but %d and %+v got", loads, s)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if g.Get(context.Background(), "Unknown"); loads != 2 {
+		t.Fatalf("expect the negative entry to expire, but %d loads got", loads)
+	}
+
+	// Setting the key drops the negative entry
+	g.Set(context.Background(), "Unknown", []byte("known"), 0)
+	if v, err := g.Get(context.Background(), "Unknown"); err != nil || v.String() != "known" {
+		t.Fatalf("expect the value set, but %v (%v) got", v, err)
+	}
+
+	// Other errors are not cached
+	g.Get(context.Background(), "Broken")
+	g.Get(context.Background(), "Broken")
+	if loads != 4 {
+		t.Fatalf("expect the other errors not to be cached, but %d loads got", loads)
+	}
+}
+
+// notFoundPeer reports every key not found.
+type notFoundPeer struct {
+	fakePeer
+}
+
+func (p *notFoundPeer) PickPeer(key string) (mayflycache.PeerGetter, bool) {
+	return p, true
+}
+
+func (p *notFoundPeer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	p.fakePeer.Get(ctx, in, out)
+	out.Value, out.NotFound = nil, true
+	return nil
+}
+
+func TestNegativeCacheFromPeer(t *testing.T) {
+	loads := 0
+	g := mayflycache.NewGroup("negative-peer", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			loads++
+			return []byte(key), nil
+		},
+	), mayflycache.WithNegativeCache(1<<10, time.Minute))
+	peer := &notFoundPeer{}
+	g.RegisterPeers(peer)
+
+	for i := 0; i < 2; i++ {
+		if _, err := g.Get(context.Background(), "Unknown"); !errors.Is(err, mayflycache.ErrNotFound) {
+			t.Fatalf("expect ErrNotFound, but %v got", err)
+		}
+	}
+	// The not found key is neither loaded locally nor requested again
+	if s := g.Stats(); loads != 0 || peer.calls != 1 || s.PeerErrors != 0 {
+		t.Fatalf("expect 1 peer call only, but %d loads and %d calls got", loads, peer.calls)
+	}
+}
+
+func TestHTTPPoolNotFound(t *testing.T) {
+	mayflycache.NewGroup("negative-remote", 2<<10, mayflycache.GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			return nil, mayflycache.ErrNotFound
+		},
+	))
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config.Handler = mayflycache.NewHTTPPool("http://" + ts.Listener.Addr().String())
+	ts.Start()
+	defer ts.Close()
+
+	client := mayflycache.NewHTTPPool("http://client")
+	client.Set(ts.URL)
+	peer, _ := client.PickPeer("Unknown")
+
+	res := &pb.Response{}
+	if err := peer.Get(context.Background(), &pb.Request{Group: "negative-remote", Key: "Unknown"}, res); err != nil {
+		t.Fatalf("expect not found as a response instead of %v", err)
+	}
+	if !res.GetNotFound() {
+		t.Fatalf("expect the response to be not found")
+	}
+
+	batch := &pb.BatchResponse{}
+	if err := peer.GetMany(context.Background(), &pb.BatchRequest{Group: "negative-remote", Keys: []string{"Unknown"}}, batch); err != nil {
+		t.Fatalf("get many from peer failed: %v", err)
+	}
+	if len(batch.Results) != 1 || !batch.Results[0].GetNotFound() || batch.Results[0].GetError() != "" {
+		t.Fatalf("expect the result to be not found, but %v got", batch.Results)
+	}
+}
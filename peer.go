@@ -1,14 +1,34 @@
-package main
+package mayflycache
 
-import pb "github.com/hey-kong/mayflycache/mayflycachepb"
+import (
+	"context"
+
+	pb "github.com/hey-kong/mayflycache/mayflycachepb"
+)
 
 // A PeerPicker interface uses a key to find the PeerGetter
 // according to the consistent hash algorithm.
+// GetAll returns all the peers except the current node.
 type PeerPicker interface {
 	PickPeer(key string) (peer PeerGetter, ok bool)
+	GetAll() []PeerGetter
 }
 
-// A PeerGetter interface is used to get the cached value from the group.
+// A PeerGetter interface is used to get the cached value from the group,
+// or set and delete it on the peer, the request is canceled when ctx is done.
+// GetMany gets the values of many keys owned by the peer in one request.
 type PeerGetter interface {
-	Get(in *pb.Request, out *pb.Response) error
+	Get(ctx context.Context, in *pb.Request, out *pb.Response) error
+	GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error
+	Set(ctx context.Context, in *pb.SetRequest) error
+	Delete(ctx context.Context, in *pb.Request) error
+}
+
+// An OwnerPicker is a PeerPicker whose PickPeer may route around the owner
+// of a key, e.g. when its circuit is open, PickOwner returns the owner
+// itself, ok is false if the current node owns the key. The writes are
+// sent to the owner, so they aren't stored on a peer serving the key for
+// a while only.
+type OwnerPicker interface {
+	PickOwner(key string) (peer PeerGetter, ok bool)
 }
@@ -0,0 +1,78 @@
+package mayflycache
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// WithStaleWhileRevalidate keeps the expired values in the mainCache for
+// maxStale more, a Get of such a stale value returns it at once while a
+// single background refresh, deduplicated with the other loads of the key
+// through Once, repopulates it.
+func WithStaleWhileRevalidate(maxStale time.Duration) GroupOption {
+	return func(g *Group) {
+		g.maxStale = maxStale
+	}
+}
+
+// WithRefreshAhead refreshes the values in the mainCache in background
+// when they are accessed within window before they expire, so the
+// popular keys are reloaded before any Get misses them.
+func WithRefreshAhead(window time.Duration) GroupOption {
+	return func(g *Group) {
+		g.refreshAhead = window
+	}
+}
+
+// keepStale makes the cache keep the value for maxStale after it expires.
+func (g *Group) keepStale(value Chunk) Chunk {
+	if g.maxStale > 0 && !value.e.IsZero() {
+		value.k = value.e.Add(g.maxStale)
+	}
+	return value
+}
+
+// revalidate refreshes the value of the key got from the mainCache in
+// background if it is stale or about to expire.
+func (g *Group) revalidate(key string, value Chunk) {
+	e := value.Expire()
+	if e.IsZero() {
+		return
+	}
+	now := time.Now()
+	switch {
+	case now.After(e):
+		g.stats.staleHits.Add(1)
+	case g.refreshAhead > 0 && now.After(e.Add(-g.refreshAhead)):
+	default:
+		return
+	}
+	g.refresh(key)
+}
+
+// refresh reloads the key in background unless it is being refreshed.
+func (g *Group) refresh(key string) {
+	g.refreshMu.Lock()
+	if g.refreshing[key] {
+		g.refreshMu.Unlock()
+		return
+	}
+	if g.refreshing == nil {
+		g.refreshing = make(map[string]bool)
+	}
+	g.refreshing[key] = true
+	g.refreshMu.Unlock()
+
+	g.stats.refreshes.Add(1)
+	go func() {
+		defer func() {
+			g.refreshMu.Lock()
+			delete(g.refreshing, key)
+			g.refreshMu.Unlock()
+		}()
+		if _, err := g.load(context.Background(), key); err != nil {
+			log.Println("Failed to refresh", key, err)
+		}
+	}()
+}
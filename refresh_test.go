@@ -0,0 +1,106 @@
+package mayflycache_test
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hey-kong/mayflycache"
+)
+
+// versionGetter returns the number of the loads as the value, each load
+// after the first waits for release.
+type versionGetter struct {
+	loads   int32
+	release chan struct{}
+}
+
+func (vg *versionGetter) Get(ctx context.Context, key string) ([]byte, error) {
+	n := atomic.AddInt32(&vg.loads, 1)
+	if n > 1 && vg.release != nil {
+		<-vg.release
+	}
+	return []byte(strconv.Itoa(int(n))), nil
+}
+
+// waitFor polls cond until it holds or the timeout expires.
+func waitFor(cond func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	vg := &versionGetter{release: make(chan struct{})}
+	g := mayflycache.NewGroup("stale", 2<<10, vg,
+		mayflycache.WithTTL(20*time.Millisecond), mayflycache.WithStaleWhileRevalidate(time.Minute))
+
+	if v, _ := g.Get(context.Background(), "Tom"); v.String() != "1" {
+		t.Fatalf("expect 1, but %v got", v)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// The stale value is served while a single refresh is blocked
+	for i := 0; i < 10; i++ {
+		v, err := g.Get(context.Background(), "Tom")
+		if err != nil || v.String() != "1" || v.Expire().After(time.Now()) {
+			t.Fatalf("expect the stale value 1, but %v (%v) got", v, err)
+		}
+	}
+	if s := g.Stats(); s.StaleHits != 10 || s.Refreshes != 1 || s.Misses != 1 {
+		t.Fatalf("expect 10 stale hits and 1 refresh, but %+v got", s)
+	}
+
+	close(vg.release)
+	if !waitFor(func() bool {
+		v, _ := g.Get(context.Background(), "Tom")
+		return v.String() == "2"
+	}) {
+		t.Fatalf("expect the value to be refreshed")
+	}
+	if n := atomic.LoadInt32(&vg.loads); n != 2 {
+		t.Fatalf("expect 2 loads, but %d got", n)
+	}
+
+	// Without the option the expired value is loaded again
+	plain := mayflycache.NewGroup("stale-plain", 2<<10, &versionGetter{}, mayflycache.WithTTL(20*time.Millisecond))
+	plain.Get(context.Background(), "Tom")
+	time.Sleep(30 * time.Millisecond)
+	if v, _ := plain.Get(context.Background(), "Tom"); v.String() != "2" {
+		t.Fatalf("expect the expired value to be reloaded, but %v got", v)
+	}
+}
+
+func TestRefreshAhead(t *testing.T) {
+	vg := &versionGetter{}
+	g := mayflycache.NewGroup("refresh-ahead", 2<<10, vg,
+		mayflycache.WithTTL(100*time.Millisecond), mayflycache.WithRefreshAhead(50*time.Millisecond))
+
+	first, _ := g.Get(context.Background(), "Tom")
+	g.Get(context.Background(), "Tom")
+	if s := g.Stats(); s.Refreshes != 0 {
+		t.Fatalf("expect no refresh out of the window, but %d got", s.Refreshes)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if v, _ := g.Get(context.Background(), "Tom"); v.String() != "1" {
+		t.Fatalf("expect the cached value 1, but %v got", v)
+	}
+	if !waitFor(func() bool { return atomic.LoadInt32(&vg.loads) == 2 }) {
+		t.Fatalf("expect the value to be refreshed ahead")
+	}
+	if !waitFor(func() bool {
+		v, _ := g.Get(context.Background(), "Tom")
+		return v.String() == "2" && v.Expire().After(first.Expire())
+	}) {
+		t.Fatalf("expect the refreshed value with a later expiration")
+	}
+	if s := g.Stats(); s.Misses != 1 || s.StaleHits != 0 {
+		t.Fatalf("expect a single miss and no stale hit, but %+v got", s)
+	}
+}
@@ -0,0 +1,86 @@
+package mayflycache
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// pushTimeout bounds each push to a replica, in addition to the request
+// timeout of the PeerPicker, so a hung replica doesn't hold the owner.
+const pushTimeout = 5 * time.Second
+
+// A ReplicaPicker is a PeerPicker that also picks the replicas of a key,
+// the successive nodes on the hash ring from its owner, which keep the
+// value so it survives the loss of the owner. peers are the replicas
+// except the current node, self reports whether it is one of them.
+type ReplicaPicker interface {
+	PickReplicas(key string) (peers []PeerGetter, self bool)
+}
+
+// pickReplicas returns the replicas of the key if the PeerPicker is a ReplicaPicker.
+func (g *Group) pickReplicas(key string) ([]PeerGetter, bool) {
+	if rp, ok := g.peers.(ReplicaPicker); ok {
+		return rp.PickReplicas(key)
+	}
+	return nil, false
+}
+
+// A route is where the current node loads a key from.
+type route struct {
+	peer    PeerGetter // the peer to load the key from, nil means the Getter
+	owner   bool       // whether the current node owns the key
+	replica bool       // whether the current node is a replica of the key
+	detour  bool       // whether the peer is asked to load the key routed around its owner
+}
+
+// pickPeer returns the route of the key. A replica loads it from the
+// owner, the others load it from any of the replicas, so the load of a
+// popular key is spread. The PeerPicker may route around the owner, e.g.
+// if it is overloaded or down, then the peer picked instead, or the
+// current node, loads the key by the Getter.
+func (g *Group) pickPeer(key string) route {
+	if g.peers == nil {
+		return route{owner: true}
+	}
+	owner, ok := g.pickOwner(key)
+	if !ok {
+		return route{owner: true}
+	}
+	peers, self := g.pickReplicas(key)
+	if !self && len(peers) >= 2 {
+		return route{peer: peers[rand.Intn(len(peers))]}
+	}
+	r := route{replica: self}
+	if peer, ok := g.peers.PickPeer(key); ok {
+		r.peer, r.detour = peer, peer != owner
+	}
+	return r
+}
+
+// replicate pushes the value of the key to the replicas except skip in
+// parallel, the failed pushes are only logged as the owner still has the
+// value.
+func (g *Group) replicate(ctx context.Context, key string, value Chunk, peers []PeerGetter, skip PeerGetter) {
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		if peer == skip {
+			continue
+		}
+		wg.Add(1)
+		go func(peer PeerGetter) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, pushTimeout)
+			defer cancel()
+			if err := g.setToPeer(ctx, peer, key, value.b, value.Expire()); err != nil {
+				g.stats.replicaPushErrs.Add(1)
+				log.Println("Failed to push to replica:", err)
+				return
+			}
+			g.stats.replicaPushes.Add(1)
+		}(peer)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,215 @@
+package mayflycache_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hey-kong/mayflycache"
+	pb "github.com/hey-kong/mayflycache/mayflycachepb"
+)
+
+// replicaPicker picks owner for every key, nil means the current node,
+// and the replicas of the key are replicas and the current node if self.
+type replicaPicker struct {
+	owner    *fakePeer
+	replicas []*fakePeer
+	self     bool
+}
+
+func (p *replicaPicker) PickPeer(key string) (mayflycache.PeerGetter, bool) {
+	if p.owner == nil {
+		return nil, false
+	}
+	return p.owner, true
+}
+
+func (p *replicaPicker) GetAll() []mayflycache.PeerGetter {
+	var peers []mayflycache.PeerGetter
+	for _, peer := range p.replicas {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+func (p *replicaPicker) PickReplicas(key string) ([]mayflycache.PeerGetter, bool) {
+	return p.GetAll(), p.self
+}
+
+// hasSet reports whether the key has been set on the peer.
+func (p *fakePeer) hasSet(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.sets[key]
+	return ok
+}
+
+func TestReplicationOwner(t *testing.T) {
+	g := mayflycache.NewGroup("replication-owner", 2<<10, localGetter())
+	picker := &replicaPicker{replicas: []*fakePeer{{}, {}}, self: true}
+	g.RegisterPeers(picker)
+
+	if v, err := g.Get(context.Background(), "Name"); err != nil || v.String() != "local-Name" {
+		t.Fatalf("expect local-Name, but %v (%v) got", v, err)
+	}
+	if !waitFor(func() bool { return g.Stats().ReplicaPushes == 2 }) {
+		t.Fatalf("expect 2 pushes, but %d got", g.Stats().ReplicaPushes)
+	}
+	if !picker.replicas[0].hasSet("Name") || !picker.replicas[1].hasSet("Name") {
+		t.Fatalf("expect the loaded value to be pushed to the replicas")
+	}
+
+	// The value set on the owner is pushed as well
+	g.Set(context.Background(), "Age", []byte("21"), 0)
+	if !picker.replicas[0].hasSet("Age") || !picker.replicas[1].hasSet("Age") {
+		t.Fatalf("expect the value set to be pushed to the replicas")
+	}
+}
+
+// stuckPeer doesn't answer the Set requests until they are abandoned.
+type stuckPeer struct {
+	fakePeer
+}
+
+func (p *stuckPeer) Set(ctx context.Context, in *pb.SetRequest) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// pushPicker makes the current node own every key, replicated to replicas.
+type pushPicker struct {
+	replicas []mayflycache.PeerGetter
+}
+
+func (p *pushPicker) PickPeer(key string) (mayflycache.PeerGetter, bool) {
+	return nil, false
+}
+
+func (p *pushPicker) GetAll() []mayflycache.PeerGetter {
+	return p.replicas
+}
+
+func (p *pushPicker) PickReplicas(key string) ([]mayflycache.PeerGetter, bool) {
+	return p.replicas, true
+}
+
+func TestReplicationStuck(t *testing.T) {
+	g := mayflycache.NewGroup("replication-stuck", 2<<10, localGetter())
+	alive := &fakePeer{}
+	g.RegisterPeers(&pushPicker{replicas: []mayflycache.PeerGetter{&stuckPeer{}, alive}})
+
+	// The stuck replica doesn't hold the push to the alive one
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	g.Set(ctx, "Age", []byte("21"), 0)
+	if !alive.hasSet("Age") {
+		t.Fatalf("expect the value to be pushed to the alive replica")
+	}
+	if s := g.Stats(); s.ReplicaPushes != 1 || s.ReplicaPushErrs != 1 {
+		t.Fatalf("expect 1 push and 1 failed push, but %+v got", s)
+	}
+}
+
+func TestReplicationReadAny(t *testing.T) {
+	g := mayflycache.NewGroup("replication-read", 2<<10, localGetter())
+	owner := &fakePeer{}
+	picker := &replicaPicker{owner: owner, replicas: []*fakePeer{owner, {}, {}}}
+	g.RegisterPeers(picker)
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if v, err := g.Get(context.Background(), key); err != nil || v.String() != "peer-"+key {
+			t.Fatalf("expect peer-%s, but %v (%v) got", key, v, err)
+		}
+	}
+	for i, peer := range picker.replicas {
+		if peer.calls == 0 {
+			t.Fatalf("expect the keys to be read from every replica, but none from %d", i)
+		}
+	}
+	if s := g.Stats(); s.MainCache.Items != 0 || s.ReplicaPushes != 0 {
+		t.Fatalf("expect the non-replica neither to keep nor push the values, but %+v got", s)
+	}
+}
+
+func TestReplicationReplica(t *testing.T) {
+	g := mayflycache.NewGroup("replication-replica", 2<<10, localGetter())
+	owner, other := &fakePeer{}, &fakePeer{}
+	picker := &replicaPicker{owner: owner, replicas: []*fakePeer{owner, other}, self: true}
+	g.RegisterPeers(picker)
+
+	// A replica loads the key from the owner and keeps it
+	if v, err := g.Get(context.Background(), "Name"); err != nil || v.String() != "peer-Name" {
+		t.Fatalf("expect peer-Name, but %v (%v) got", v, err)
+	}
+	g.Get(context.Background(), "Name")
+	if s := g.Stats(); owner.calls != 1 || other.calls != 0 || s.MainCache.Items != 1 {
+		t.Fatalf("expect the replica to keep the value of the owner, but %d calls and %+v got", owner.calls, s)
+	}
+
+	// The value set on a replica is sent to the owner and the other replicas
+	g.Set(context.Background(), "Age", []byte("21"), 0)
+	if !owner.hasSet("Age") || !other.hasSet("Age") {
+		t.Fatalf("expect the value set to be sent to all the replicas")
+	}
+	if s := g.Stats(); s.ReplicaPushes != 1 || s.MainCache.Items != 2 {
+		t.Fatalf("expect 1 push and the value kept, but %+v got", s)
+	}
+}
+
+// aroundPicker routes the reads of the keys of owner to the current node.
+type aroundPicker struct {
+	replicaPicker
+}
+
+func (p *aroundPicker) PickPeer(key string) (mayflycache.PeerGetter, bool) {
+	return nil, false
+}
+
+func (p *aroundPicker) PickOwner(key string) (mayflycache.PeerGetter, bool) {
+	return p.owner, true
+}
+
+func TestReplicationRoutedAround(t *testing.T) {
+	g := mayflycache.NewGroup("replication-around", 2<<10, localGetter(), mayflycache.WithHotCache(2<<10, 0))
+	owner := &fakePeer{}
+	g.RegisterPeers(&aroundPicker{replicaPicker{owner: owner, replicas: []*fakePeer{owner}}})
+
+	// The current node loads the key instead of the owner, but it neither
+	// keeps the value like the owner nor pushes it to the replicas
+	if v, err := g.Get(context.Background(), "Name"); err != nil || v.String() != "local-Name" {
+		t.Fatalf("expect local-Name, but %v (%v) got", v, err)
+	}
+	if s := g.Stats(); owner.calls != 0 || s.MainCache.Items != 0 || s.HotCache.Items != 1 || s.ReplicaPushes != 0 {
+		t.Fatalf("expect the value in the hotCache only, but %d calls and %+v got", owner.calls, s)
+	}
+}
+
+func TestHTTPPoolPickReplicas(t *testing.T) {
+	hp := mayflycache.NewHTTPPool("http://client", mayflycache.WithReplication(2))
+	hp.Set("http://a", "http://b", "http://c", "http://client")
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		peers, self := hp.PickReplicas(key)
+		n := len(peers)
+		if self {
+			n++
+		}
+		if n != 2 {
+			t.Fatalf("expect 2 replicas of %s, but %v (%v) got", key, peers, self)
+		}
+		if owner, ok := hp.PickPeer(key); ok && peers[0] != owner {
+			t.Fatalf("expect the owner %v to be the first replica, but %v got", owner, peers)
+		}
+		if len(peers) == 2 && peers[0] == peers[1] {
+			t.Fatalf("expect distinct replicas, but %v got", peers)
+		}
+	}
+
+	plain := mayflycache.NewHTTPPool("http://client")
+	plain.Set("http://a", "http://b")
+	if peers, self := plain.PickReplicas("key"); len(peers) != 0 || self {
+		t.Fatalf("expect no replica without replication, but %v got", peers)
+	}
+}
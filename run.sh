@@ -1,7 +1,7 @@
 #!/bin/bash
 trap "rm server;kill 0" EXIT
 
-go build -o server
+go build -o server ./cmd/mayflycache
 ./server -port=8001 &
 ./server -port=8002 &
 ./server -port=8003 -api=1 &
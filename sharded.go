@@ -0,0 +1,132 @@
+package mayflycache
+
+import (
+	"time"
+
+	"github.com/hey-kong/mayflycache/eviction"
+)
+
+// cache is the cache of a Group, it is either a SafeCache
+// or a ShardedCache.
+type cache interface {
+	Get(key string) (Chunk, bool)
+	Set(key string, value Chunk)
+	Delete(key string)
+	Bytes() int64
+	Len() int
+	Stats() CacheStats
+	RemoveExpired() int
+	StartJanitor(interval time.Duration)
+	StopJanitor()
+}
+
+// ShardedCache spreads the keys over the SafeCache shards by their hash,
+// each shard has its own lock and an equal part of the bytes, so the
+// requests for different keys rarely wait for each other.
+type ShardedCache struct {
+	shards []*SafeCache
+}
+
+// NewShardedCache returns a ShardedCache of n shards that holds at most
+// maxBytes in total, 0 means no limit. The entries are evicted by the
+// policy created with newPolicy in each shard. There are at most maxBytes
+// shards, as a shard of 0 bytes would hold any number of entries.
+func NewShardedCache(maxBytes int64, n int, newPolicy eviction.NewFunc) *ShardedCache {
+	if maxBytes > 0 && int64(n) > maxBytes {
+		n = int(maxBytes)
+	}
+	if n < 1 {
+		n = 1
+	}
+	c := &ShardedCache{shards: make([]*SafeCache, n)}
+	for i := range c.shards {
+		shardBytes := maxBytes / int64(n)
+		if int64(i) < maxBytes%int64(n) {
+			shardBytes++
+		}
+		c.shards[i] = NewSafeCacheWithPolicy(shardBytes, newPolicy)
+	}
+	return c
+}
+
+// shard returns the shard of the key by the FNV-1a hash,
+// it is inlined to avoid the allocation of hash/fnv.
+func (c *ShardedCache) shard(key string) *SafeCache {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return c.shards[h%uint32(len(c.shards))]
+}
+
+// Get returns the value of the key from its shard.
+func (c *ShardedCache) Get(key string) (Chunk, bool) {
+	return c.shard(key).Get(key)
+}
+
+// Set stores the value of the key in its shard.
+func (c *ShardedCache) Set(key string, value Chunk) {
+	c.shard(key).Set(key, value)
+}
+
+// Delete removes the key from its shard.
+func (c *ShardedCache) Delete(key string) {
+	c.shard(key).Delete(key)
+}
+
+// Bytes returns the bytes of memory in use of all the shards.
+func (c *ShardedCache) Bytes() int64 {
+	var n int64
+	for _, s := range c.shards {
+		n += s.Bytes()
+	}
+	return n
+}
+
+// Len returns how many entries are cached in all the shards.
+func (c *ShardedCache) Len() int {
+	n := 0
+	for _, s := range c.shards {
+		n += s.Len()
+	}
+	return n
+}
+
+// Stats returns the sum of the counters of the shards.
+func (c *ShardedCache) Stats() CacheStats {
+	var stats CacheStats
+	for _, s := range c.shards {
+		ss := s.Stats()
+		stats.Bytes += ss.Bytes
+		stats.Items += ss.Items
+		stats.Gets += ss.Gets
+		stats.Hits += ss.Hits
+		stats.Evictions += ss.Evictions
+		stats.Expirations += ss.Expirations
+	}
+	return stats
+}
+
+// RemoveExpired removes the expired entries of all the shards.
+func (c *ShardedCache) RemoveExpired() int {
+	n := 0
+	for _, s := range c.shards {
+		n += s.RemoveExpired()
+	}
+	return n
+}
+
+// StartJanitor starts the janitor of every shard.
+func (c *ShardedCache) StartJanitor(interval time.Duration) {
+	for _, s := range c.shards {
+		s.StartJanitor(interval)
+	}
+}
+
+// StopJanitor stops the janitors started by StartJanitor.
+func (c *ShardedCache) StopJanitor() {
+	for _, s := range c.shards {
+		s.StopJanitor()
+	}
+}
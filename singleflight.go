@@ -1,9 +1,11 @@
-package main
+package mayflycache
 
 import (
+	"context"
 	"sync"
 )
 
+// Once makes the concurrent calls with the same key execute the function only once.
 type Once struct {
 	mu sync.Mutex
 	m  map[string]*call
@@ -11,38 +13,78 @@ type Once struct {
 
 // A call is used to handle the function call corresponding to the string in Once.
 type call struct {
-	wg  sync.WaitGroup // there may be multiple function calls waiting for the same result
-	val interface{}    // the value returned by the function call
-	err error
+	done      chan struct{} // closed when the function call returns, there may be multiple calls waiting for it
+	val       interface{}   // the value returned by the function call
+	err       error
+	abandoned bool // whether the function call failed after its caller had given up
 }
 
-func (o *Once) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+// Do executes fn and returns its result, the callers with the same key
+// that arrive while fn is running wait for it and share the result.
+// A waiting caller abandons the shared call and returns ctx.Err()
+// when its ctx is done, fn itself should observe the ctx of the first caller.
+// If the shared call fails because the first caller has given up, the
+// waiting callers whose ctx is still live call their own fn again.
+func (o *Once) Do(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error) {
+	for {
+		c, leader := o.begin(key)
+		if !leader {
+			val, retry, err := o.wait(ctx, c)
+			if retry {
+				continue
+			}
+			return val, err
+		}
+
+		// Call and get the value, and notify the waiting calls
+		val, err := fn()
+		o.finish(key, c, val, err, err != nil && ctx.Err() != nil)
+		return val, err
+	}
+}
+
+// begin returns the call of the key in flight, or a new one if leader
+// is true, which the caller must execute and finish.
+func (o *Once) begin(key string) (c *call, leader bool) {
 	o.mu.Lock()
+	defer o.mu.Unlock()
 	if o.m == nil {
 		o.m = make(map[string]*call)
 	}
 
 	// Judge if function call with the key has occurred
 	if c, ok := o.m[key]; ok {
-		o.mu.Unlock()
-		c.wg.Wait()
-		return c.val, c.err
+		return c, false
 	}
-
 	// Here is the first call
-	c := new(call)
-	c.wg.Add(1)
+	c = &call{done: make(chan struct{})}
 	o.m[key] = c
-	o.mu.Unlock()
+	return c, true
+}
 
-	// Call and get the value, and notify the waiting calls
-	c.val, c.err = fn()
-	c.wg.Done()
+// wait waits for the call begun by another caller, retry reports whether
+// the call has been abandoned by its caller while ctx is still live.
+func (o *Once) wait(ctx context.Context, c *call) (val interface{}, retry bool, err error) {
+	select {
+	case <-c.done:
+		if c.abandoned && ctx.Err() == nil {
+			return nil, true, nil
+		}
+		return c.val, false, c.err
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
 
-	// Remove the result of this call
+// finish records the result of the call begun by the leader and
+// notifies the waiting calls.
+func (o *Once) finish(key string, c *call, val interface{}, err error, abandoned bool) {
+	c.val, c.err, c.abandoned = val, err, abandoned
+
+	// Remove this call before notifying the waiting calls,
+	// so the ones retrying don't find it again
 	o.mu.Lock()
 	delete(o.m, key)
 	o.mu.Unlock()
-
-	return c.val, c.err
+	close(c.done)
 }
@@ -0,0 +1,80 @@
+package mayflycache
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// An AtomicInt is an int64 to be accessed atomically.
+type AtomicInt int64
+
+// Add atomically adds n to i.
+func (i *AtomicInt) Add(n int64) {
+	atomic.AddInt64((*int64)(i), n)
+}
+
+// Get atomically gets the value of i.
+func (i *AtomicInt) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+func (i *AtomicInt) String() string {
+	return strconv.FormatInt(i.Get(), 10)
+}
+
+// groupStats are the counters of a Group, updated atomically.
+type groupStats struct {
+	gets            AtomicInt
+	hits            AtomicInt
+	misses          AtomicInt
+	peerLoads       AtomicInt
+	peerErrors      AtomicInt
+	localLoads      AtomicInt
+	localLoadErrs   AtomicInt
+	replicaPushes   AtomicInt
+	replicaPushErrs AtomicInt
+	dedups          AtomicInt
+	negativeHits    AtomicInt
+	filterRejects   AtomicInt
+	staleHits       AtomicInt
+	refreshes       AtomicInt
+	retries         AtomicInt
+	hedges          AtomicInt
+	hedgeWins       AtomicInt
+}
+
+// Stats is a snapshot of the statistics of a Group.
+type Stats struct {
+	Gets            int64 // any Get request, including from peers
+	Hits            int64 // either the mainCache or the hotCache was good
+	Misses          int64 // neither cache was good, the key is loaded
+	PeerLoads       int64 // remote loads that succeeded
+	PeerErrors      int64 // remote loads that failed
+	LocalLoads      int64 // loads by the Getter that succeeded
+	LocalLoadErrs   int64 // loads by the Getter that failed
+	ReplicaPushes   int64 // values pushed to the replicas
+	ReplicaPushErrs int64 // pushes to the replicas that failed
+	Dedups          int64 // loads that shared the result of a concurrent one
+	NegativeHits    int64 // keys served as not found by the negCache
+	FilterRejects   int64 // keys rejected as not found by the Bloom filter
+	StaleHits       int64 // expired values served stale while being refreshed
+	Refreshes       int64 // background refreshes of the stale or about to expire values
+	Retries         int64 // retried Get requests to peers
+	Hedges          int64 // duplicate requests sent because the owner was slow
+	HedgeWins       int64 // duplicate requests answered before the owner
+	Evictions       int64 // entries evicted from both caches
+
+	MainCache     CacheStats
+	HotCache      CacheStats
+	NegativeCache CacheStats
+}
+
+// CacheStats is a snapshot of the statistics of a SafeCache.
+type CacheStats struct {
+	Bytes       int64
+	Items       int64
+	Gets        int64
+	Hits        int64
+	Evictions   int64
+	Expirations int64
+}
@@ -0,0 +1,608 @@
+// Package swim implements the SWIM membership protocol: nodes probe each
+// other with ping and indirect ping-req, suspect the members that don't
+// answer, declare them dead after the suspicion timeout, and spread the
+// membership changes by piggybacking them on the protocol messages.
+package swim
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// State is the state of a member.
+type State int
+
+const (
+	StateAlive State = iota
+	StateSuspect
+	StateDead
+)
+
+func (s State) String() string {
+	switch s {
+	case StateAlive:
+		return "alive"
+	case StateSuspect:
+		return "suspect"
+	case StateDead:
+		return "dead"
+	}
+	return "unknown"
+}
+
+// A Member is a node in the cluster.
+type Member struct {
+	Name        string `json:"name"` // unique name, e.g. the base URL of the cache peer
+	Addr        string `json:"addr"` // address of the Transport
+	State       State  `json:"state"`
+	Incarnation uint64 `json:"inc"` // only the member itself increases it to refute suspicion
+}
+
+// Config configures a Node, the zero durations and counts use the defaults.
+type Config struct {
+	Name      string
+	Transport Transport
+
+	ProbeInterval    time.Duration // interval of probing a member, 1s by default
+	ProbeTimeout     time.Duration // timeout of the direct ping, 500ms by default
+	IndirectChecks   int           // members asked to ping-req on a failed ping, 3 by default
+	SuspicionTimeout time.Duration // time before a suspect is declared dead, 5s by default
+	SyncInterval     time.Duration // interval of the full state sync with a random member, 30s by default
+	RetransmitMult   int           // multiplier of the times an update is gossiped, 3 by default
+
+	// OnJoin is called when a member joins or comes back alive,
+	// OnLeave is called when a member is dead or leaves.
+	OnJoin  func(Member)
+	OnLeave func(Member)
+}
+
+// maxPiggyback is the most updates carried by a message.
+const maxPiggyback = 8
+
+type msgType uint8
+
+const (
+	pingMsg msgType = iota
+	ackMsg
+	pingReqMsg
+	syncMsg
+	syncAckMsg
+)
+
+type message struct {
+	Type    msgType  `json:"type"`
+	Seq     uint64   `json:"seq,omitempty"`
+	From    string   `json:"from"`              // address of the sender
+	Target  string   `json:"target,omitempty"`  // address of the member to probe for ping-req
+	Members []Member `json:"members,omitempty"` // full state for sync
+	Updates []Member `json:"updates,omitempty"` // piggybacked gossip
+}
+
+type member struct {
+	Member
+	suspicion *time.Timer // running while the member is suspect
+}
+
+type broadcast struct {
+	m         Member
+	transmits int
+}
+
+// A Node is a member of the cluster running the protocol.
+type Node struct {
+	config Config
+	self   Member
+
+	mu         sync.Mutex
+	members    map[string]*member // other members keyed by name
+	broadcasts []*broadcast
+	acks       map[uint64]func()
+	seq        uint64
+	probeOrder []string
+	joined     chan struct{}
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New starts a Node that only knows about itself, call Join to
+// contact the seeds of the cluster.
+func New(config Config) *Node {
+	if config.ProbeInterval == 0 {
+		config.ProbeInterval = time.Second
+	}
+	if config.ProbeTimeout == 0 {
+		config.ProbeTimeout = config.ProbeInterval / 2
+	}
+	if config.IndirectChecks == 0 {
+		config.IndirectChecks = 3
+	}
+	if config.SuspicionTimeout == 0 {
+		config.SuspicionTimeout = 5 * config.ProbeInterval
+	}
+	if config.SyncInterval == 0 {
+		config.SyncInterval = 30 * config.ProbeInterval
+	}
+	if config.RetransmitMult == 0 {
+		config.RetransmitMult = 3
+	}
+
+	n := &Node{
+		config: config,
+		self: Member{
+			Name:  config.Name,
+			Addr:  config.Transport.Addr(),
+			State: StateAlive,
+		},
+		members: make(map[string]*member),
+		acks:    make(map[uint64]func()),
+		joined:  make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	n.wg.Add(3)
+	go n.receive()
+	go n.probeLoop()
+	go n.syncLoop()
+	return n
+}
+
+// Join exchanges the full state with the seeds, it returns an error if
+// none of them answers within the ProbeInterval.
+func (n *Node) Join(seeds ...string) error {
+	sent := false
+	for _, seed := range seeds {
+		if seed == n.self.Addr {
+			continue
+		}
+		n.send(seed, &message{Type: syncMsg, Members: n.state()})
+		sent = true
+	}
+	if !sent {
+		return nil
+	}
+
+	select {
+	case <-n.joined:
+		return nil
+	case <-time.After(n.config.ProbeInterval):
+		return errors.New("swim: no seed answered")
+	case <-n.stop:
+		return errors.New("swim: node is shut down")
+	}
+}
+
+// Leave tells the other members that the node is leaving, then shuts it down.
+func (n *Node) Leave() error {
+	n.mu.Lock()
+	n.self.Incarnation++
+	left := n.self
+	left.State = StateDead
+	var addrs []string
+	for _, m := range n.members {
+		if m.State != StateDead {
+			addrs = append(addrs, m.Addr)
+		}
+	}
+	n.mu.Unlock()
+
+	for _, addr := range addrs {
+		n.send(addr, &message{Type: syncMsg, Members: []Member{left}})
+	}
+	return n.Shutdown()
+}
+
+// Shutdown stops the node without notifying the other members.
+func (n *Node) Shutdown() error {
+	select {
+	case <-n.stop:
+		return nil
+	default:
+	}
+	close(n.stop)
+	err := n.config.Transport.Close()
+	n.wg.Wait()
+
+	n.mu.Lock()
+	for _, m := range n.members {
+		if m.suspicion != nil {
+			m.suspicion.Stop()
+		}
+	}
+	n.mu.Unlock()
+	return err
+}
+
+// Members returns the members that are not dead, including the node itself,
+// sorted by name.
+func (n *Node) Members() []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	members := []Member{n.self}
+	for _, m := range n.members {
+		if m.State != StateDead {
+			members = append(members, m.Member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Name < members[j].Name
+	})
+	return members
+}
+
+// state returns all the known members including the dead ones.
+func (n *Node) state() []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	members := []Member{n.self}
+	for _, m := range n.members {
+		members = append(members, m.Member)
+	}
+	return members
+}
+
+func (n *Node) receive() {
+	defer n.wg.Done()
+	for pkt := range n.config.Transport.Packets() {
+		var msg message
+		if err := json.Unmarshal(pkt.Buf, &msg); err != nil {
+			continue
+		}
+		n.handle(&msg)
+	}
+}
+
+func (n *Node) handle(msg *message) {
+	n.merge(msg.Updates)
+
+	switch msg.Type {
+	case pingMsg:
+		n.send(msg.From, &message{Type: ackMsg, Seq: msg.Seq})
+	case ackMsg:
+		n.mu.Lock()
+		fn := n.acks[msg.Seq]
+		n.mu.Unlock()
+		if fn != nil {
+			fn()
+		}
+	case pingReqMsg:
+		// Probe the target on behalf of the sender,
+		// and forward the ack with the sequence of the sender.
+		from, seq := msg.From, msg.Seq
+		n.probe(msg.Target, func() {
+			n.send(from, &message{Type: ackMsg, Seq: seq})
+		})
+	case syncMsg:
+		n.merge(msg.Members)
+		n.send(msg.From, &message{Type: syncAckMsg, Members: n.state()})
+	case syncAckMsg:
+		n.merge(msg.Members)
+		select {
+		case n.joined <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// probe pings addr and calls onAck when it answers within the ProbeTimeout.
+func (n *Node) probe(addr string, onAck func()) {
+	seq := n.registerAck(onAck)
+	time.AfterFunc(n.config.ProbeTimeout, func() {
+		n.deleteAck(seq)
+	})
+	n.send(addr, &message{Type: pingMsg, Seq: seq})
+}
+
+// registerAck returns a new sequence, fn is called when it is acked.
+func (n *Node) registerAck(fn func()) uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.seq++
+	n.acks[n.seq] = fn
+	return n.seq
+}
+
+func (n *Node) deleteAck(seq uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.acks, seq)
+}
+
+func (n *Node) probeLoop() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.config.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.probeNext()
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+// probeNext probes the next member in a round-robin way, and
+// suspects it if neither the ping nor the ping-req is answered.
+func (n *Node) probeNext() {
+	target, ok := n.nextProbeTarget()
+	if !ok {
+		return
+	}
+
+	acked := make(chan struct{}, 1)
+	onAck := func() {
+		select {
+		case acked <- struct{}{}:
+		default:
+		}
+	}
+	seq := n.registerAck(onAck)
+	defer n.deleteAck(seq)
+
+	n.send(target.Addr, &message{Type: pingMsg, Seq: seq})
+	select {
+	case <-acked:
+		return
+	case <-time.After(n.config.ProbeTimeout):
+	case <-n.stop:
+		return
+	}
+
+	// The acks forwarded by the helpers carry the same sequence
+	for _, m := range n.randomMembers(n.config.IndirectChecks, target.Name) {
+		n.send(m.Addr, &message{Type: pingReqMsg, Seq: seq, Target: target.Addr})
+	}
+	select {
+	case <-acked:
+		return
+	case <-time.After(n.config.ProbeInterval - n.config.ProbeTimeout):
+	case <-n.stop:
+		return
+	}
+
+	suspect := target
+	suspect.State = StateSuspect
+	n.merge([]Member{suspect})
+}
+
+func (n *Node) nextProbeTarget() (Member, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for {
+		// Shuffle the members when a round is finished
+		if len(n.probeOrder) == 0 {
+			for name, m := range n.members {
+				if m.State != StateDead {
+					n.probeOrder = append(n.probeOrder, name)
+				}
+			}
+			if len(n.probeOrder) == 0 {
+				return Member{}, false
+			}
+			rand.Shuffle(len(n.probeOrder), func(i, j int) {
+				n.probeOrder[i], n.probeOrder[j] = n.probeOrder[j], n.probeOrder[i]
+			})
+		}
+		name := n.probeOrder[0]
+		n.probeOrder = n.probeOrder[1:]
+		if m, ok := n.members[name]; ok && m.State != StateDead {
+			return m.Member, true
+		}
+	}
+}
+
+// randomMembers returns at most k random members that are alive except the excluded one.
+func (n *Node) randomMembers(k int, exclude string) []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var members []Member
+	for name, m := range n.members {
+		if name != exclude && m.State == StateAlive {
+			members = append(members, m.Member)
+		}
+	}
+	rand.Shuffle(len(members), func(i, j int) {
+		members[i], members[j] = members[j], members[i]
+	})
+	if len(members) > k {
+		members = members[:k]
+	}
+	return members
+}
+
+// syncLoop exchanges the full state with a random member periodically,
+// the dead members are included so that partitions can be healed.
+func (n *Node) syncLoop() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.config.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.mu.Lock()
+			var addrs []string
+			for _, m := range n.members {
+				addrs = append(addrs, m.Addr)
+			}
+			n.mu.Unlock()
+			if len(addrs) > 0 {
+				n.send(addrs[rand.Intn(len(addrs))], &message{Type: syncMsg, Members: n.state()})
+			}
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+// merge applies the updates about the members, and calls
+// the callbacks for the members that join or leave.
+func (n *Node) merge(updates []Member) {
+	var joined, left []Member
+	n.mu.Lock()
+	for _, m := range updates {
+		switch n.apply(m) {
+		case StateAlive:
+			joined = append(joined, m)
+		case StateDead:
+			left = append(left, m)
+		}
+	}
+	n.mu.Unlock()
+
+	for _, m := range joined {
+		if n.config.OnJoin != nil {
+			n.config.OnJoin(m)
+		}
+	}
+	for _, m := range left {
+		if n.config.OnLeave != nil {
+			n.config.OnLeave(m)
+		}
+	}
+}
+
+// apply must be called with n.mu held, it returns StateAlive if the
+// member joins, StateDead if it leaves, and StateSuspect otherwise.
+func (n *Node) apply(m Member) State {
+	if m.Name == n.self.Name {
+		// Refute the suspicion by a newer incarnation
+		if m.State != StateAlive && m.Incarnation >= n.self.Incarnation {
+			n.self.Incarnation = m.Incarnation + 1
+			n.queue(n.self)
+		}
+		return StateSuspect
+	}
+
+	cur, ok := n.members[m.Name]
+	if !ok {
+		// There is no need to learn about a dead member
+		if m.State == StateDead {
+			return StateSuspect
+		}
+		cur = &member{Member: m}
+		n.members[m.Name] = cur
+		if m.State == StateSuspect {
+			n.startSuspicion(cur)
+		}
+		n.queue(m)
+		return StateAlive
+	}
+
+	switch m.State {
+	case StateAlive:
+		if m.Incarnation <= cur.Incarnation {
+			return StateSuspect
+		}
+		wasDead := cur.State == StateDead
+		n.stopSuspicion(cur)
+		cur.Member = m
+		n.queue(m)
+		if wasDead {
+			return StateAlive
+		}
+	case StateSuspect:
+		if cur.State == StateDead || m.Incarnation < cur.Incarnation ||
+			(cur.State == StateSuspect && m.Incarnation == cur.Incarnation) {
+			return StateSuspect
+		}
+		cur.State, cur.Incarnation = StateSuspect, m.Incarnation
+		n.startSuspicion(cur)
+		n.queue(cur.Member)
+	case StateDead:
+		if cur.State == StateDead || m.Incarnation < cur.Incarnation {
+			return StateSuspect
+		}
+		n.stopSuspicion(cur)
+		cur.State, cur.Incarnation = StateDead, m.Incarnation
+		n.queue(cur.Member)
+		return StateDead
+	}
+	return StateSuspect
+}
+
+// startSuspicion must be called with n.mu held.
+func (n *Node) startSuspicion(m *member) {
+	n.stopSuspicion(m)
+	inc := m.Incarnation
+	m.suspicion = time.AfterFunc(n.config.SuspicionTimeout, func() {
+		n.mu.Lock()
+		if m.State != StateSuspect || m.Incarnation != inc {
+			n.mu.Unlock()
+			return
+		}
+		dead := m.Member
+		dead.State = StateDead
+		n.mu.Unlock()
+		n.merge([]Member{dead})
+	})
+}
+
+// stopSuspicion must be called with n.mu held.
+func (n *Node) stopSuspicion(m *member) {
+	if m.suspicion != nil {
+		m.suspicion.Stop()
+		m.suspicion = nil
+	}
+}
+
+// queue must be called with n.mu held, it replaces
+// the pending update about the same member.
+func (n *Node) queue(m Member) {
+	for _, b := range n.broadcasts {
+		if b.m.Name == m.Name {
+			b.m, b.transmits = m, 0
+			return
+		}
+	}
+	n.broadcasts = append(n.broadcasts, &broadcast{m: m})
+}
+
+// piggyback returns the updates to gossip, each of them is sent at most
+// RetransmitMult * log10(N+1) times.
+func (n *Node) piggyback() []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	limit := n.config.RetransmitMult * int(math.Ceil(math.Log10(float64(len(n.members)+2))))
+	sort.SliceStable(n.broadcasts, func(i, j int) bool {
+		return n.broadcasts[i].transmits < n.broadcasts[j].transmits
+	})
+	var updates []Member
+	for _, b := range n.broadcasts {
+		if len(updates) == maxPiggyback {
+			break
+		}
+		updates = append(updates, b.m)
+		b.transmits++
+	}
+
+	remaining := n.broadcasts[:0]
+	for _, b := range n.broadcasts {
+		if b.transmits < limit {
+			remaining = append(remaining, b)
+		}
+	}
+	n.broadcasts = remaining
+	return updates
+}
+
+func (n *Node) send(addr string, msg *message) {
+	msg.From = n.self.Addr
+	msg.Updates = n.piggyback()
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	n.config.Transport.WriteTo(b, addr)
+}
@@ -0,0 +1,202 @@
+package swim
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// cluster is a group of nodes on a MemNetwork, node i is named
+// "node<i>" and its address is "addr<i>".
+type cluster struct {
+	network *MemNetwork
+	nodes   []*Node
+
+	mu     sync.Mutex
+	events map[string][]string // events observed by each node, e.g. "+node1" or "-node1"
+}
+
+func newCluster(t *testing.T, size int) *cluster {
+	c := &cluster{
+		network: NewMemNetwork(),
+		events:  make(map[string][]string),
+	}
+	for i := 0; i < size; i++ {
+		c.nodes = append(c.nodes, c.start(i))
+	}
+	for _, n := range c.nodes[1:] {
+		if err := n.Join(c.nodes[0].self.Addr); err != nil {
+			t.Fatalf("%s failed to join: %v", n.self.Name, err)
+		}
+	}
+	return c
+}
+
+func (c *cluster) start(i int) *Node {
+	name := fmt.Sprintf("node%d", i)
+	return New(Config{
+		Name:             name,
+		Transport:        c.network.NewTransport(fmt.Sprintf("addr%d", i)),
+		ProbeInterval:    20 * time.Millisecond,
+		ProbeTimeout:     10 * time.Millisecond,
+		SuspicionTimeout: 100 * time.Millisecond,
+		SyncInterval:     50 * time.Millisecond,
+		OnJoin: func(m Member) {
+			c.mu.Lock()
+			c.events[name] = append(c.events[name], "+"+m.Name)
+			c.mu.Unlock()
+		},
+		OnLeave: func(m Member) {
+			c.mu.Lock()
+			c.events[name] = append(c.events[name], "-"+m.Name)
+			c.mu.Unlock()
+		},
+	})
+}
+
+func (c *cluster) shutdown() {
+	for _, n := range c.nodes {
+		n.Shutdown()
+	}
+}
+
+func (c *cluster) lastEvent(node string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	events := c.events[node]
+	if len(events) == 0 {
+		return ""
+	}
+	return events[len(events)-1]
+}
+
+// eventually waits until the members of every node in nodes are want.
+func eventually(t *testing.T, nodes []*Node, want ...string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, n := range nodes {
+		for {
+			got := names(n.Members())
+			if fmt.Sprint(got) == fmt.Sprint(want) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: expect members %v, but %v got", n.self.Name, want, got)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func names(members []Member) []string {
+	var names []string
+	for _, m := range members {
+		names = append(names, m.Name)
+	}
+	return names
+}
+
+func TestJoin(t *testing.T) {
+	c := newCluster(t, 3)
+	defer c.shutdown()
+
+	eventually(t, c.nodes, "node0", "node1", "node2")
+	if e := c.lastEvent("node0"); e != "+node1" && e != "+node2" {
+		t.Fatalf("expect node0 to observe the joins, but %q got", e)
+	}
+}
+
+func TestJoinNoSeed(t *testing.T) {
+	network := NewMemNetwork()
+	n := New(Config{Name: "node0", Transport: network.NewTransport("addr0"), ProbeInterval: 20 * time.Millisecond})
+	defer n.Shutdown()
+
+	if err := n.Join("addr0"); err != nil {
+		t.Fatalf("joining itself should be a no-op, but %v got", err)
+	}
+	if err := n.Join("unknown"); err == nil {
+		t.Fatalf("expect an error when no seed answers")
+	}
+}
+
+func TestFailure(t *testing.T) {
+	c := newCluster(t, 3)
+	defer c.shutdown()
+	eventually(t, c.nodes, "node0", "node1", "node2")
+
+	c.nodes[2].Shutdown()
+	eventually(t, c.nodes[:2], "node0", "node1")
+	for _, name := range []string{"node0", "node1"} {
+		if e := c.lastEvent(name); e != "-node2" {
+			t.Fatalf("expect %s to observe node2 failed, but %q got", name, e)
+		}
+	}
+}
+
+func TestLeave(t *testing.T) {
+	c := newCluster(t, 3)
+	defer c.shutdown()
+	eventually(t, c.nodes, "node0", "node1", "node2")
+
+	c.nodes[1].Leave()
+	eventually(t, []*Node{c.nodes[0], c.nodes[2]}, "node0", "node2")
+}
+
+func TestIndirectPing(t *testing.T) {
+	c := newCluster(t, 3)
+	defer c.shutdown()
+	eventually(t, c.nodes, "node0", "node1", "node2")
+
+	// node0 and node1 can only reach each other through node2
+	c.network.Partition([]string{"addr0"}, []string{"addr1"})
+	time.Sleep(5 * c.nodes[0].config.SuspicionTimeout)
+	eventually(t, c.nodes, "node0", "node1", "node2")
+}
+
+func TestPartition(t *testing.T) {
+	c := newCluster(t, 4)
+	defer c.shutdown()
+	eventually(t, c.nodes, "node0", "node1", "node2", "node3")
+
+	c.network.Partition([]string{"addr0", "addr1"}, []string{"addr2", "addr3"})
+	eventually(t, c.nodes[:2], "node0", "node1")
+	eventually(t, c.nodes[2:], "node2", "node3")
+
+	c.network.Heal()
+	eventually(t, c.nodes, "node0", "node1", "node2", "node3")
+}
+
+func TestRejoin(t *testing.T) {
+	c := newCluster(t, 3)
+	defer c.shutdown()
+	eventually(t, c.nodes, "node0", "node1", "node2")
+
+	c.nodes[2].Shutdown()
+	eventually(t, c.nodes[:2], "node0", "node1")
+
+	// node2 restarts with the same name and a new incarnation history
+	c.nodes[2] = c.start(2)
+	if err := c.nodes[2].Join("addr0"); err != nil {
+		t.Fatalf("node2 failed to rejoin: %v", err)
+	}
+	eventually(t, c.nodes, "node0", "node1", "node2")
+}
+
+func TestUDPTransport(t *testing.T) {
+	var nodes []*Node
+	for i := 0; i < 2; i++ {
+		tr, err := NewUDPTransport("127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen udp failed: %v", err)
+		}
+		n := New(Config{Name: fmt.Sprintf("node%d", i), Transport: tr, ProbeInterval: 50 * time.Millisecond})
+		defer n.Shutdown()
+		nodes = append(nodes, n)
+	}
+
+	if err := nodes[1].Join(nodes[0].self.Addr); err != nil {
+		t.Fatalf("node1 failed to join: %v", err)
+	}
+	eventually(t, nodes, "node0", "node1")
+}
@@ -0,0 +1,186 @@
+package swim
+
+import (
+	"net"
+	"sync"
+)
+
+// A Packet is a message received by the Transport.
+type Packet struct {
+	Buf  []byte
+	From string // address of the sender
+}
+
+// A Transport sends and receives unreliable messages between nodes.
+type Transport interface {
+	// Addr returns the address other nodes use to reach this one.
+	Addr() string
+	// WriteTo sends b to addr, the message may be lost silently.
+	WriteTo(b []byte, addr string) error
+	// Packets returns the channel of received messages,
+	// it is closed when the Transport is closed.
+	Packets() <-chan Packet
+	Close() error
+}
+
+// maxPacketSize is the largest message the UDPTransport can receive.
+const maxPacketSize = 65536
+
+// UDPTransport is a Transport over UDP.
+type UDPTransport struct {
+	conn    net.PacketConn
+	packets chan Packet
+}
+
+// NewUDPTransport listens on the UDP address, e.g. "localhost:9001".
+func NewUDPTransport(addr string) (*UDPTransport, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	t := &UDPTransport{
+		conn:    conn,
+		packets: make(chan Packet, 64),
+	}
+	go t.read()
+	return t, nil
+}
+
+func (t *UDPTransport) read() {
+	defer close(t.packets)
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := t.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		b := make([]byte, n)
+		copy(b, buf[:n])
+		t.packets <- Packet{Buf: b, From: from.String()}
+	}
+}
+
+// Addr implements Transport interface function.
+func (t *UDPTransport) Addr() string {
+	return t.conn.LocalAddr().String()
+}
+
+// WriteTo implements Transport interface function.
+func (t *UDPTransport) WriteTo(b []byte, addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	_, err = t.conn.WriteTo(b, udpAddr)
+	return err
+}
+
+// Packets implements Transport interface function.
+func (t *UDPTransport) Packets() <-chan Packet {
+	return t.packets
+}
+
+// Close implements Transport interface function.
+func (t *UDPTransport) Close() error {
+	return t.conn.Close()
+}
+
+// MemNetwork connects in-process Transports, it can drop the messages
+// between groups of nodes to simulate network partitions in tests.
+type MemNetwork struct {
+	mu         sync.Mutex
+	transports map[string]*memTransport
+	blocked    map[[2]string]bool // pairs of addresses that can't reach each other
+}
+
+// NewMemNetwork returns an empty MemNetwork.
+func NewMemNetwork() *MemNetwork {
+	return &MemNetwork{
+		transports: make(map[string]*memTransport),
+		blocked:    make(map[[2]string]bool),
+	}
+}
+
+// NewTransport returns a Transport with the address on the network.
+func (n *MemNetwork) NewTransport(addr string) Transport {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	t := &memTransport{
+		network: n,
+		addr:    addr,
+		packets: make(chan Packet, 256),
+	}
+	n.transports[addr] = t
+	return t
+}
+
+// Partition drops the messages between every address in a and every one in b.
+func (n *MemNetwork) Partition(a, b []string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, x := range a {
+		for _, y := range b {
+			n.blocked[[2]string{x, y}] = true
+			n.blocked[[2]string{y, x}] = true
+		}
+	}
+}
+
+// Heal removes all the partitions.
+func (n *MemNetwork) Heal() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.blocked = make(map[[2]string]bool)
+}
+
+func (n *MemNetwork) deliver(b []byte, from, to string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	t, ok := n.transports[to]
+	if !ok || t.closed || n.blocked[[2]string{from, to}] {
+		return
+	}
+	buf := make([]byte, len(b))
+	copy(buf, b)
+	// Like UDP, the message is dropped if the receiver can't keep up
+	select {
+	case t.packets <- Packet{Buf: buf, From: from}:
+	default:
+	}
+}
+
+type memTransport struct {
+	network *MemNetwork
+	addr    string
+	packets chan Packet
+	closed  bool // guarded by network.mu
+}
+
+func (t *memTransport) Addr() string {
+	return t.addr
+}
+
+func (t *memTransport) WriteTo(b []byte, addr string) error {
+	t.network.deliver(b, t.addr, addr)
+	return nil
+}
+
+func (t *memTransport) Packets() <-chan Packet {
+	return t.packets
+}
+
+func (t *memTransport) Close() error {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+
+	if !t.closed {
+		t.closed = true
+		delete(t.network.transports, t.addr)
+		close(t.packets)
+	}
+	return nil
+}